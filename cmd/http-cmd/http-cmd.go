@@ -15,6 +15,7 @@ func main() {
 
 	versionFlag := flag.Bool("version", false, "Get version")
 	configFlag := flag.String("config", config.DefaultConfPath, "Configuration file ["+config.DefaultConfPath+"]")
+	genkeyFlag := flag.Bool("genkey", false, "Generate an API key and its hash to configure")
 	flag.Parse()
 
 	if *versionFlag {
@@ -23,6 +24,17 @@ func main() {
 		return
 	}
 
+	if *genkeyFlag {
+		key, hash, err := config.GenerateAPIKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can not generate API key: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Key:    \t%s\n", key)
+		fmt.Printf("Hash:   \t%s\n", hash)
+		return
+	}
+
 	cfg, err := config.New(*configFlag)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/signing"
+)
+
+// Sign signs r with the secret named key shared with the server, setting its
+// Authorization header and, unless already set, its X-Content-SHA256 header.
+// If it is not set, the body of r is read to be hashed, and replaced so that r
+// can still be sent. Setting it beforehand allows the body to be streamed.
+func Sign(r *http.Request, key string, secret []byte) error {
+	if r.Header.Get(signing.ContentHashHeader) == "" {
+		var body []byte
+		if r.Body != nil {
+			b, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				return err
+			}
+			body = b
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			r.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			}
+		}
+		r.Header.Set(signing.ContentHashHeader, signing.HashBody(body))
+	}
+
+	n := make([]byte, 16)
+	if _, err := rand.Read(n); err != nil {
+		return err
+	}
+	a := signing.Authorization{
+		Key:       key,
+		Timestamp: time.Now().Unix(),
+		Nonce:     hex.EncodeToString(n),
+	}
+	a.Signature = signing.Signature(secret, signing.StringToSign(r, a.Timestamp, a.Nonce))
+	r.Header.Set("Authorization", a.String())
+	return nil
+}
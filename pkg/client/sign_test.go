@@ -0,0 +1,66 @@
+package client_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/etombini/http-cmd/pkg/client"
+	"github.com/etombini/http-cmd/pkg/signing"
+)
+
+func TestSign(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	r, err := http.NewRequest(http.MethodPost, "http://localhost:5151/run/system/echo?b=2&a=1", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Sign(r, "deploy", secret); err != nil {
+		t.Fatal("Can not sign request: " + err.Error())
+	}
+
+	a, ok, err := signing.ParseAuthorization(r.Header.Get("Authorization"))
+	if !ok || err != nil {
+		t.Fatalf("Can not parse Authorization header %q: %v", r.Header.Get("Authorization"), err)
+	}
+	if a.Key != "deploy" {
+		t.Errorf("Key is %s, expected deploy", a.Key)
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil || string(body) != "hello" {
+		t.Errorf("Body is %q after signing, expected hello", string(body))
+	}
+	if r.Header.Get(signing.ContentHashHeader) != signing.HashBody(body) {
+		t.Errorf("Content hash is %s, expected the hash of the body", r.Header.Get(signing.ContentHashHeader))
+	}
+	if signing.Signature(secret, signing.StringToSign(r, a.Timestamp, a.Nonce)) != a.Signature {
+		t.Error("Signature does not match the request")
+	}
+
+	other, err := http.NewRequest(http.MethodGet, "http://localhost:5151/catalog/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Sign(other, "deploy", secret); err != nil {
+		t.Fatal("Can not sign request without body: " + err.Error())
+	}
+	b, _, _ := signing.ParseAuthorization(other.Header.Get("Authorization"))
+	if b.Nonce == a.Nonce {
+		t.Error("Nonce is reused between requests")
+	}
+
+	// the body is not read when its hash is given
+	streamed, err := http.NewRequest(http.MethodPost, "http://localhost:5151/run/system/cat", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed.Header.Set(signing.ContentHashHeader, signing.HashBody([]byte("hello")))
+	streamed.GetBody = nil
+	if err := client.Sign(streamed, "deploy", secret); err != nil {
+		t.Fatal("Can not sign streamed request: " + err.Error())
+	}
+	if streamed.GetBody != nil {
+		t.Error("Body of streamed request is read")
+	}
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// APIKeyHashPrefix is the prefix of the hash of an API key, telling the hash function
+const APIKeyHashPrefix string = "sha256:"
+
+// APIKey is a structure handling an API key given by clients in an
+// "Authorization: Bearer" or "X-API-Key" header, and what it is granted.
+// Only the hash of the key is stored, as given by HashAPIKey.
+type APIKey struct {
+	Name  string `yaml:"name"`
+	Hash  string `yaml:"hash"`
+	Grant `yaml:",inline"`
+}
+
+// APIKeysFile is a structure handling a file holding API keys
+type APIKeysFile struct {
+	APIKeys []APIKey `yaml:"api_keys"`
+}
+
+// GenerateAPIKey returns a new random API key and its hash
+func GenerateAPIKey() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key := hex.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hash of an API key as stored in the configuration.
+// API keys are random, a fast hash function is thus enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return APIKeyHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// checkAPIKeys loads the API keys of file, relative to the directory of the
+// configuration file, after the ones of keys, and checks all of them
+func checkAPIKeys(keys []APIKey, file string, configPath string) ([]APIKey, error) {
+	if file != "" {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(configPath), file)
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.New("can not read API keys file " + file + ": " + err.Error())
+		}
+		var f APIKeysFile
+		if err := yaml.Unmarshal(content, &f); err != nil {
+			return nil, errors.New("can not parse API keys file " + file + ": " + err.Error())
+		}
+		keys = append(keys, f.APIKeys...)
+	}
+
+	names := make(map[string]bool)
+	for i := range keys {
+		if keys[i].Name == "" {
+			return nil, errors.New("API key name can not be an empty string")
+		}
+		if names[keys[i].Name] {
+			return nil, errors.New("API key duplicate found: " + keys[i].Name)
+		}
+		names[keys[i].Name] = true
+		sum, err := hex.DecodeString(strings.TrimPrefix(keys[i].Hash, APIKeyHashPrefix))
+		if !strings.HasPrefix(keys[i].Hash, APIKeyHashPrefix) || err != nil || len(sum) != sha256.Size {
+			return nil, errors.New("API key " + keys[i].Name + " hash must be " + APIKeyHashPrefix + " followed by a hex encoded SHA-256")
+		}
+	}
+	return keys, nil
+}
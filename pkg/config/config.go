@@ -3,14 +3,19 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"net"
+	"net/http"
 	"os"
+	"os/user"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"errors"
 
+	"github.com/etombini/http-cmd/pkg/hangman"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -29,22 +34,61 @@ const (
 	DefaultCatalogPrefix string = "/catalog/"
 	// DefaultExecPrefix is the default URL prefix to reach command execution
 	DefaultExecPrefix string = "/run/"
+	// DefaultJobsPrefix is the default URL prefix to reach asynchronous jobs
+	DefaultJobsPrefix string = "/jobs/"
+	// DefaultStreamPrefix is the default URL prefix to reach command execution with live output
+	DefaultStreamPrefix string = "/stream/"
+	// DefaultWebsocketPrefix is the default URL prefix to reach interactive execs over a WebSocket
+	DefaultWebsocketPrefix string = "/ws/"
+	// DefaultJobRetention is the default number of seconds a finished job is kept
+	DefaultJobRetention uint32 = 3600
+	// DefaultMaxJobs is the default maximum number of jobs kept, running or finished
+	DefaultMaxJobs uint32 = 100
+	// DefaultHMACMaxSkew is the default number of seconds a signed request timestamp may differ from the server time
+	DefaultHMACMaxSkew uint32 = 300
+	// DefaultShell is the default shell used by execs running in shell mode
+	DefaultShell string = "/bin/sh"
+	// StdinNone means the command is not given any standard input
+	StdinNone string = "none"
+	// StdinJSON means the standard input of the command is the "stdin" field of a JSON request body
+	StdinJSON string = "json"
+	// StdinRequestBody means the request body is streamed to the standard input of the command
+	StdinRequestBody string = "request_body"
+	// DefaultContentType is the default content type of the raw output of an exec
+	DefaultContentType string = "application/octet-stream"
+	// DefaultStdinMaxBytes is the default maximum number of bytes given to the standard input of a command
+	DefaultStdinMaxBytes int64 = 1024 * 1024
+	// DefaultMaxOutputBytes is the default maximum number of bytes kept from stdout and from stderr
+	DefaultMaxOutputBytes int64 = 1024 * 1024
+	// UnlimitedOutputBytes is the max_output_bytes value keeping the whole stdout and stderr
+	UnlimitedOutputBytes int64 = -1
 	// LoggerName is the default logger name for this package
 	LoggerName string = "config"
-	// //DefaultUser is the default user id which runs http-cmd application
-	// DefaultUser string = "http-cmd"
 )
 
 // Config is a structure representing the global application configuration
 type Config struct {
 	Server struct {
-		Address       string `yaml:"address"`
-		Port          uint32 `yaml:"port"`
-		Timeout       uint32 `yaml:"timeout"`
-		CatalogPrefix string `yaml:"catalog_prefix"`
-		ExecPrefix    string `yaml:"exec_prefix"`
-		//		User          string `yaml:"user"`
-		UID uint32
+		Address         string         `yaml:"address"`
+		Port            uint32         `yaml:"port"`
+		Timeout         uint32         `yaml:"timeout"`
+		CatalogPrefix   string         `yaml:"catalog_prefix"`
+		ExecPrefix      string         `yaml:"exec_prefix"`
+		JobsPrefix      string         `yaml:"jobs_prefix"`
+		StreamPrefix    string         `yaml:"stream_prefix"`
+		WebsocketPrefix string         `yaml:"websocket_prefix"`
+		JobRetention    uint32         `yaml:"job_retention"`
+		MaxJobs         uint32         `yaml:"max_jobs"`
+		Shell           string         `yaml:"shell"`
+		MaxOutputBytes  int64          `yaml:"max_output_bytes"`
+		RunAs           *RunAs         `yaml:"run_as"`
+		StatusMapping   *StatusMapping `yaml:"status_mapping"`
+		TLS             `yaml:",inline"`
+		APIKeys         []APIKey `yaml:"api_keys"`
+		APIKeysFile     string   `yaml:"api_keys_file"`
+		Htpasswd        `yaml:",inline"`
+		HMACKeys        []HMACKey `yaml:"hmac_keys"`
+		HMACMaxSkew     uint32    `yaml:"hmac_max_skew"`
 	}
 
 	FilePath   string
@@ -66,10 +110,82 @@ type Execs struct {
 
 // Exec is a structure handling exec configuration
 type Exec struct {
-	Name        string `yaml:"name"`
-	Description string `yaml:"description"`
-	Command     string `yaml:"command"`
-	Timeout     uint32 `yaml:"timeout"`
+	Name             string            `yaml:"name"`
+	Description      string            `yaml:"description"`
+	Command          string            `yaml:"command"`
+	Args             []string          `yaml:"args"`
+	Shell            bool              `yaml:"shell"`
+	Timeout          uint32            `yaml:"timeout"`
+	KillGrace        uint32            `yaml:"kill_grace"`
+	MaxOutputBytes   int64             `yaml:"max_output_bytes"`
+	KeepTail         bool              `yaml:"keep_tail"`
+	Limits           *Limits           `yaml:"limits"`
+	RunAs            *RunAs            `yaml:"run_as"`
+	Workdir          string            `yaml:"workdir"`
+	Env              map[string]string `yaml:"env"`
+	InheritEnv       *InheritEnv       `yaml:"inherit_env"`
+	Parameters       []Parameter       `yaml:"parameters"`
+	Methods          []string          `yaml:"methods"`
+	Stdin            string            `yaml:"stdin"`
+	StdinMaxBytes    int64             `yaml:"stdin_max_bytes"`
+	StdinContentType string            `yaml:"stdin_content_type"`
+	Async            bool              `yaml:"async"`
+	Websocket        bool              `yaml:"websocket"`
+	PTY              bool              `yaml:"pty"`
+	StatusMapping    *StatusMapping    `yaml:"status_mapping"`
+	OutputEncoding   string            `yaml:"output_encoding"`
+	ContentType      string            `yaml:"content_type"`
+}
+
+// DefaultInheritEnv is the list of variables of the http-cmd environment an exec
+// inherits when inherit_env is not set, so that secrets given to http-cmd are not
+// passed to commands unless asked to
+var DefaultInheritEnv = []string{"PATH", "HOME", "LANG"}
+
+// InheritEnv is a structure handling which variables of the http-cmd environment
+// an exec inherits. In yaml, it is either a boolean (all variables or none of
+// them) or a list of variable names.
+type InheritEnv struct {
+	All       bool
+	Allowlist []string
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (i *InheritEnv) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var all bool
+	if err := unmarshal(&all); err == nil {
+		i.All = all
+		return nil
+	}
+	var allowlist []string
+	if err := unmarshal(&allowlist); err != nil {
+		return errors.New("inherit_env must be a boolean or a list of variable names")
+	}
+	i.Allowlist = allowlist
+	return nil
+}
+
+// RunAs is a structure handling the account an exec is run as.
+// If Group is not set, the primary group of User is used.
+// UID, GID and Groups are resolved when the configuration is loaded.
+type RunAs struct {
+	User                string   `yaml:"user"`
+	Group               string   `yaml:"group"`
+	SupplementaryGroups []string `yaml:"supplementary_groups"`
+	UID                 uint32   `yaml:"-"`
+	GID                 uint32   `yaml:"-"`
+	Groups              []uint32 `yaml:"-"`
+}
+
+// Limits is a structure handling resource limits configuration of an exec.
+// CPU is in seconds, AddressSpace, FileSize and CoreSize are in bytes.
+type Limits struct {
+	CPU          *uint64 `yaml:"cpu"`
+	AddressSpace *uint64 `yaml:"address_space"`
+	OpenFiles    *uint64 `yaml:"open_files"`
+	Processes    *uint64 `yaml:"processes"`
+	FileSize     *uint64 `yaml:"file_size"`
+	CoreSize     *uint64 `yaml:"core_size"`
 }
 
 func checkServerDefault(c *Config) error {
@@ -118,10 +234,106 @@ func checkServerDefault(c *Config) error {
 			c.Server.CatalogPrefix,
 			c.Server.ExecPrefix)
 	}
-	// if c.Server.User == "" {
-	// 	fmt.Fprintf(os.Stderr, "User is not set, defaulting to %s\n", DefaultUser)
-	// 	c.Server.User = DefaultUser
-	// }
+	if c.Server.JobsPrefix == "" {
+		fmt.Fprintf(os.Stderr, "Jobs prefix is not set, defaulting to %s\n", DefaultJobsPrefix)
+		c.Server.JobsPrefix = DefaultJobsPrefix
+	}
+	if !strings.HasPrefix(c.Server.JobsPrefix, "/") {
+		c.Server.JobsPrefix = "/" + c.Server.JobsPrefix
+	}
+	if !strings.HasSuffix(c.Server.JobsPrefix, "/") {
+		c.Server.JobsPrefix = c.Server.JobsPrefix + "/"
+	}
+	if c.Server.JobsPrefix == c.Server.CatalogPrefix || c.Server.JobsPrefix == c.Server.ExecPrefix {
+		fmt.Fprintf(os.Stderr, "Jobs prefix (%s) can not have the same value as Exec prefix or Catalog prefix\n", c.Server.JobsPrefix)
+		return errors.New("Jobs prefix (" + c.Server.JobsPrefix + ") can not have the same value as Exec prefix or Catalog prefix")
+	}
+	if c.Server.StreamPrefix == "" {
+		fmt.Fprintf(os.Stderr, "Stream prefix is not set, defaulting to %s\n", DefaultStreamPrefix)
+		c.Server.StreamPrefix = DefaultStreamPrefix
+	}
+	if !strings.HasPrefix(c.Server.StreamPrefix, "/") {
+		c.Server.StreamPrefix = "/" + c.Server.StreamPrefix
+	}
+	if !strings.HasSuffix(c.Server.StreamPrefix, "/") {
+		c.Server.StreamPrefix = c.Server.StreamPrefix + "/"
+	}
+	if c.Server.StreamPrefix == c.Server.CatalogPrefix || c.Server.StreamPrefix == c.Server.ExecPrefix ||
+		c.Server.StreamPrefix == c.Server.JobsPrefix {
+		fmt.Fprintf(os.Stderr, "Stream prefix (%s) can not have the same value as Exec prefix, Catalog prefix or Jobs prefix\n", c.Server.StreamPrefix)
+		return errors.New("Stream prefix (" + c.Server.StreamPrefix + ") can not have the same value as Exec prefix, Catalog prefix or Jobs prefix")
+	}
+	if c.Server.WebsocketPrefix == "" {
+		fmt.Fprintf(os.Stderr, "Websocket prefix is not set, defaulting to %s\n", DefaultWebsocketPrefix)
+		c.Server.WebsocketPrefix = DefaultWebsocketPrefix
+	}
+	if !strings.HasPrefix(c.Server.WebsocketPrefix, "/") {
+		c.Server.WebsocketPrefix = "/" + c.Server.WebsocketPrefix
+	}
+	if !strings.HasSuffix(c.Server.WebsocketPrefix, "/") {
+		c.Server.WebsocketPrefix = c.Server.WebsocketPrefix + "/"
+	}
+	if c.Server.WebsocketPrefix == c.Server.CatalogPrefix || c.Server.WebsocketPrefix == c.Server.ExecPrefix ||
+		c.Server.WebsocketPrefix == c.Server.JobsPrefix || c.Server.WebsocketPrefix == c.Server.StreamPrefix {
+		fmt.Fprintf(os.Stderr, "Websocket prefix (%s) can not have the same value as Exec prefix, Catalog prefix, Jobs prefix or Stream prefix\n", c.Server.WebsocketPrefix)
+		return errors.New("Websocket prefix (" + c.Server.WebsocketPrefix + ") can not have the same value as Exec prefix, Catalog prefix, Jobs prefix or Stream prefix")
+	}
+	if c.Server.JobRetention == 0 {
+		fmt.Fprintf(os.Stderr, "Job retention is not set, defaulting to %d\n", DefaultJobRetention)
+		c.Server.JobRetention = DefaultJobRetention
+	}
+	if c.Server.MaxJobs == 0 {
+		fmt.Fprintf(os.Stderr, "Max jobs is not set, defaulting to %d\n", DefaultMaxJobs)
+		c.Server.MaxJobs = DefaultMaxJobs
+	}
+	if c.Server.Shell == "" {
+		fmt.Fprintf(os.Stderr, "Shell is not set, defaulting to %s\n", DefaultShell)
+		c.Server.Shell = DefaultShell
+	}
+	if !filepath.IsAbs(c.Server.Shell) {
+		fmt.Fprintf(os.Stderr, "Shell (%s) must be an absolute path\n", c.Server.Shell)
+		return errors.New("Shell (" + c.Server.Shell + ") must be an absolute path")
+	}
+	if c.Server.MaxOutputBytes == 0 {
+		fmt.Fprintf(os.Stderr, "Max output bytes is not set, defaulting to %d\n", DefaultMaxOutputBytes)
+		c.Server.MaxOutputBytes = DefaultMaxOutputBytes
+	}
+	if c.Server.MaxOutputBytes < 0 && c.Server.MaxOutputBytes != UnlimitedOutputBytes {
+		fmt.Fprintf(os.Stderr, "Max output bytes must be positive, or %d for no limit\n", UnlimitedOutputBytes)
+		return errors.New("Max output bytes must be positive, or " + strconv.FormatInt(UnlimitedOutputBytes, 10) + " for no limit")
+	}
+	if c.Server.RunAs != nil {
+		if err := resolveRunAs(c.Server.RunAs); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid server run_as: %s\n", err.Error())
+			return errors.New("Invalid server run_as: " + err.Error())
+		}
+	}
+	if err := checkTLS(&c.Server.TLS, c.FilePath); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid server TLS configuration: %s\n", err.Error())
+		return errors.New("Invalid server TLS configuration: " + err.Error())
+	}
+	keys, err := checkAPIKeys(c.Server.APIKeys, c.Server.APIKeysFile, c.FilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid server API keys: %s\n", err.Error())
+		return errors.New("Invalid server API keys: " + err.Error())
+	}
+	c.Server.APIKeys = keys
+	if err := checkHtpasswd(&c.Server.Htpasswd, c.FilePath); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid server htpasswd configuration: %s\n", err.Error())
+		return errors.New("Invalid server htpasswd configuration: " + err.Error())
+	}
+	if err := checkHMACKeys(c.Server.HMACKeys); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid server HMAC keys: %s\n", err.Error())
+		return errors.New("Invalid server HMAC keys: " + err.Error())
+	}
+	if len(c.Server.HMACKeys) != 0 && c.Server.HMACMaxSkew == 0 {
+		fmt.Fprintf(os.Stderr, "HMAC max skew is not set, defaulting to %d\n", DefaultHMACMaxSkew)
+		c.Server.HMACMaxSkew = DefaultHMACMaxSkew
+	}
+	if err := checkStatusMapping(c.Server.StatusMapping); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid server status_mapping: %s\n", err.Error())
+		return errors.New("Invalid server status_mapping: " + err.Error())
+	}
 
 	return nil
 }
@@ -195,6 +407,109 @@ func loadExecs(c *Config) error {
 			m[name] = true
 		}
 
+		// check exactly one of command or args is set
+		for j := range eConfig.Execs {
+			if (eConfig.Execs[j].Command == "") == (len(eConfig.Execs[j].Args) == 0) {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) must have exactly one of command or args\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath)
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath + ") must have exactly one of command or args")
+			}
+		}
+
+		// check shell mode is only used with a command line
+		for j := range eConfig.Execs {
+			if eConfig.Execs[j].Shell && len(eConfig.Execs[j].Args) > 0 {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) can not use shell mode with args\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath)
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath + ") can not use shell mode with args")
+			}
+		}
+
+		// check command lines can be parsed, shell mode command lines are left to the shell.
+		// Variable references are checked too, expanded to nothing as their values
+		// are only known when running the command.
+		for j := range eConfig.Execs {
+			if eConfig.Execs[j].Command == "" || eConfig.Execs[j].Shell {
+				continue
+			}
+			if _, err := hangman.Split(eConfig.Execs[j].Command, func(string) string { return "" }); err != nil {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) has an invalid command: %s\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath, err.Error())
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath + ") has an invalid command: " + err.Error())
+			}
+		}
+
+		// resolve run_as, defaulting to the server one
+		for j := range eConfig.Execs {
+			if eConfig.Execs[j].RunAs == nil {
+				eConfig.Execs[j].RunAs = c.Server.RunAs
+				continue
+			}
+			if err := resolveRunAs(eConfig.Execs[j].RunAs); err != nil {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) has an invalid run_as: %s\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath, err.Error())
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath + ") has an invalid run_as: " + err.Error())
+			}
+		}
+
+		// check resource limits, which depend on the account the command is run as
+		for j := range eConfig.Execs {
+			if err := checkLimits(eConfig.Execs[j].Limits, eConfig.Execs[j].RunAs); err != nil {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) has invalid limits: %s\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath, err.Error())
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath + ") has invalid limits: " + err.Error())
+			}
+		}
+
+		// check working directory and environment
+		for j := range eConfig.Execs {
+			if err := checkEnv(&eConfig.Execs[j]); err != nil {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) has an invalid environment: %s\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath, err.Error())
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath + ") has an invalid environment: " + err.Error())
+			}
+		}
+
+		// check parameters
+		for j := range eConfig.Execs {
+			if err := checkParameters(&eConfig.Execs[j]); err != nil {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) has invalid parameters: %s\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath, err.Error())
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath + ") has invalid parameters: " + err.Error())
+			}
+		}
+
+		// check HTTP methods and standard input
+		for j := range eConfig.Execs {
+			if err := checkMethods(&eConfig.Execs[j]); err != nil {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) has invalid methods: %s\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath, err.Error())
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath + ") has invalid methods: " + err.Error())
+			}
+		}
+
+		// check output encoding and content type
+		for j := range eConfig.Execs {
+			if err := checkOutput(&eConfig.Execs[j]); err != nil {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) has an invalid output: %s\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath, err.Error())
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath + ") has an invalid output: " + err.Error())
+			}
+		}
+
+		// check status mapping, defaulting to the server one
+		for j := range eConfig.Execs {
+			if eConfig.Execs[j].StatusMapping == nil {
+				eConfig.Execs[j].StatusMapping = c.Server.StatusMapping
+				continue
+			}
+			if err := checkStatusMapping(eConfig.Execs[j].StatusMapping); err != nil {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) has an invalid status_mapping: %s\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath, err.Error())
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath + ") has an invalid status_mapping: " + err.Error())
+			}
+		}
+
 		// set default timeout if not set
 		for j := range eConfig.Execs {
 			if eConfig.Execs[j].Timeout <= 0 {
@@ -203,6 +518,28 @@ func loadExecs(c *Config) error {
 				eConfig.Execs[j].Timeout = c.Server.Timeout
 			}
 		}
+		// check the kill grace period, which is not part of the timeout
+		for j := range eConfig.Execs {
+			if eConfig.Execs[j].KillGrace > eConfig.Execs[j].Timeout {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) kill_grace (%d) can not be greater than its timeout (%d)\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath, eConfig.Execs[j].KillGrace, eConfig.Execs[j].Timeout)
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath + ") kill_grace (" +
+					strconv.FormatUint(uint64(eConfig.Execs[j].KillGrace), 10) + ") can not be greater than its timeout (" +
+					strconv.FormatUint(uint64(eConfig.Execs[j].Timeout), 10) + ")")
+			}
+		}
+		// set default max output bytes if not set
+		for j := range eConfig.Execs {
+			if eConfig.Execs[j].MaxOutputBytes < 0 && eConfig.Execs[j].MaxOutputBytes != UnlimitedOutputBytes {
+				fmt.Fprintf(os.Stderr, "Exec %s in category %s (%s) max_output_bytes must be positive, or %d for no limit\n",
+					eConfig.Execs[j].Name, c.Categories[i].Name, ePath, UnlimitedOutputBytes)
+				return errors.New("Exec " + eConfig.Execs[j].Name + " in category " + c.Categories[i].Name + " (" + ePath +
+					") max_output_bytes must be positive, or " + strconv.FormatInt(UnlimitedOutputBytes, 10) + " for no limit")
+			}
+			if eConfig.Execs[j].MaxOutputBytes == 0 {
+				eConfig.Execs[j].MaxOutputBytes = c.Server.MaxOutputBytes
+			}
+		}
 		// sort execs
 		sort.Slice(eConfig.Execs, func(i, j int) bool { return eConfig.Execs[i].Name < eConfig.Execs[j].Name })
 		c.Categories[i].Execs = eConfig.Execs
@@ -210,6 +547,185 @@ func loadExecs(c *Config) error {
 	return nil
 }
 
+func checkLimits(l *Limits, runAs *RunAs) error {
+	if l == nil {
+		return nil
+	}
+	if l.CPU != nil && *l.CPU == 0 {
+		return errors.New("cpu limit must be greater than 0")
+	}
+	if l.AddressSpace != nil && *l.AddressSpace == 0 {
+		return errors.New("address_space limit must be greater than 0")
+	}
+	if l.OpenFiles != nil && *l.OpenFiles == 0 {
+		return errors.New("open_files limit must be greater than 0")
+	}
+	if l.Processes != nil && *l.Processes == 0 {
+		return errors.New("processes limit must be greater than 0")
+	}
+	var credential *hangman.Credential
+	if runAs != nil {
+		credential = &hangman.Credential{UID: runAs.UID, GID: runAs.GID}
+	}
+	return hangman.CheckLimits(l.Hangman(), credential)
+}
+
+// Hangman returns the limits to apply to the process of an exec
+func (l *Limits) Hangman() hangman.Limits {
+	return hangman.Limits{
+		CPU:          l.CPU,
+		AddressSpace: l.AddressSpace,
+		OpenFiles:    l.OpenFiles,
+		Processes:    l.Processes,
+		FileSize:     l.FileSize,
+		CoreSize:     l.CoreSize,
+	}
+}
+
+// checkEnv checks the working directory and environment of an exec, which
+// inherits only the variables of DefaultInheritEnv by default
+func checkEnv(e *Exec) error {
+	if e.Workdir != "" {
+		if !filepath.IsAbs(e.Workdir) {
+			return errors.New("workdir " + e.Workdir + " must be an absolute path")
+		}
+		info, err := os.Stat(e.Workdir)
+		if err != nil {
+			return errors.New("workdir " + e.Workdir + " can not be accessed: " + err.Error())
+		}
+		if !info.IsDir() {
+			return errors.New("workdir " + e.Workdir + " is not a directory")
+		}
+	}
+	for name := range e.Env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return errors.New("invalid variable name \"" + name + "\"")
+		}
+	}
+	if e.InheritEnv == nil {
+		e.InheritEnv = &InheritEnv{Allowlist: append([]string(nil), DefaultInheritEnv...)}
+	}
+	return nil
+}
+
+// checkMethods checks the HTTP methods allowed to run an exec, GET by default,
+// and its standard input mode, none by default. When the request body is
+// streamed to the command, StdinContentType restricts the accepted content type.
+// An async exec is run as a job when requested with POST. An exec can also be
+// run interactively over a WebSocket, with a pseudo-terminal if PTY is set.
+func checkMethods(e *Exec) error {
+	if len(e.Methods) == 0 {
+		e.Methods = []string{http.MethodGet}
+	}
+	m := make(map[string]bool)
+	for k := range e.Methods {
+		e.Methods[k] = strings.ToUpper(e.Methods[k])
+		if e.Methods[k] != http.MethodGet && e.Methods[k] != http.MethodPost {
+			return errors.New("method " + e.Methods[k] + " is not supported, only GET and POST are")
+		}
+		if m[e.Methods[k]] {
+			return errors.New("method duplicate found: " + e.Methods[k])
+		}
+		m[e.Methods[k]] = true
+	}
+
+	switch e.Stdin {
+	case "":
+		e.Stdin = StdinNone
+	case StdinNone:
+	case StdinJSON, StdinRequestBody:
+		if !m[http.MethodPost] {
+			return errors.New("stdin " + e.Stdin + " requires the POST method")
+		}
+	default:
+		return errors.New("unknown stdin mode " + e.Stdin)
+	}
+
+	if e.Async && !m[http.MethodPost] {
+		return errors.New("async requires the POST method")
+	}
+	if e.Websocket && !m[http.MethodGet] {
+		return errors.New("websocket requires the GET method")
+	}
+	if e.PTY && !e.Websocket {
+		return errors.New("pty requires websocket")
+	}
+	for _, p := range e.Parameters {
+		if e.PTY && (p.Name == "rows" || p.Name == "cols") {
+			return errors.New("parameter " + p.Name + " is reserved for the size of the terminal")
+		}
+	}
+
+	if e.StdinMaxBytes < 0 {
+		return errors.New("stdin_max_bytes can not be negative")
+	}
+	if e.StdinMaxBytes == 0 && e.Stdin != StdinNone {
+		e.StdinMaxBytes = DefaultStdinMaxBytes
+	}
+	if e.StdinContentType != "" {
+		if e.Stdin != StdinRequestBody {
+			return errors.New("stdin_content_type requires stdin " + StdinRequestBody)
+		}
+		mediaType, _, err := mime.ParseMediaType(e.StdinContentType)
+		if err != nil {
+			return errors.New("invalid stdin_content_type " + e.StdinContentType + ": " + err.Error())
+		}
+		e.StdinContentType = mediaType
+	}
+	return nil
+}
+
+// checkOutput checks how the outputs of an exec are encoded in the execution
+// result, utf8 by default, and the content type of its raw output,
+// application/octet-stream by default
+func checkOutput(e *Exec) error {
+	switch e.OutputEncoding {
+	case "":
+		e.OutputEncoding = hangman.EncodingUTF8
+	case hangman.EncodingUTF8, hangman.EncodingBase64, hangman.EncodingAuto:
+	default:
+		return errors.New("unknown output_encoding " + e.OutputEncoding + ", expecting " +
+			hangman.EncodingUTF8 + ", " + hangman.EncodingBase64 + " or " + hangman.EncodingAuto)
+	}
+
+	if e.ContentType == "" {
+		e.ContentType = DefaultContentType
+	}
+	if _, _, err := mime.ParseMediaType(e.ContentType); err != nil {
+		return errors.New("invalid content_type " + e.ContentType + ": " + err.Error())
+	}
+	return nil
+}
+
+// checkGrants checks the categories and execs granted to clients exist
+func checkGrants(c *Config) error {
+	for i := range c.Server.TLS.Clients {
+		if err := checkGrant(&c.Server.TLS.Clients[i].Grant, c); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid grant for tls client %d: %s\n", i, err.Error())
+			return errors.New("Invalid grant for tls client " + strconv.Itoa(i) + ": " + err.Error())
+		}
+	}
+	for i := range c.Server.APIKeys {
+		if err := checkGrant(&c.Server.APIKeys[i].Grant, c); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid grant for API key %s: %s\n", c.Server.APIKeys[i].Name, err.Error())
+			return errors.New("Invalid grant for API key " + c.Server.APIKeys[i].Name + ": " + err.Error())
+		}
+	}
+	for i := range c.Server.Htpasswd.Groups {
+		if err := checkGrant(&c.Server.Htpasswd.Groups[i].Grant, c); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid grant for htpasswd group %s: %s\n", c.Server.Htpasswd.Groups[i].Name, err.Error())
+			return errors.New("Invalid grant for htpasswd group " + c.Server.Htpasswd.Groups[i].Name + ": " + err.Error())
+		}
+	}
+	for i := range c.Server.HMACKeys {
+		if err := checkGrant(&c.Server.HMACKeys[i].Grant, c); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid grant for HMAC key %s: %s\n", c.Server.HMACKeys[i].Name, err.Error())
+			return errors.New("Invalid grant for HMAC key " + c.Server.HMACKeys[i].Name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
 func checkExecNames(c *Config) error {
 	for i := range c.Categories {
 		for j := range c.Categories[i].Execs {
@@ -226,22 +742,59 @@ func checkExecNames(c *Config) error {
 	return nil
 }
 
-// func setuid(c *Config) error {
-// 	u, err := user.Lookup(c.Server.User)
-// 	if err != nil {
-// 		fmt.Fprintf(os.Stderr, "Unknow user %s\n", c.Server.User)
-// 		return errors.New("Unknown user " + c.Server.User)
-// 	}
-
-// 	uid, err := strconv.ParseUint(u.Uid, 10, 32)
-// 	if err != nil {
-// 		fmt.Fprintf(os.Stderr, "Error while parsing uid %s\n", u.Uid)
-// 		return errors.New("Error while parsing uid " + u.Uid)
-// 	}
-// 	c.Server.UID = uint32(uid)
-// 	fmt.Printf("Server uid is %d\n", c.Server.UID)
-// 	return nil
-// }
+func lookupGroup(name string) (uint32, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, errors.New("unknown group " + name)
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, errors.New("error while parsing gid " + g.Gid + " of group " + name)
+	}
+	return uint32(gid), nil
+}
+
+// resolveRunAs looks up the user and groups of r, and checks http-cmd is allowed to
+// switch to them
+func resolveRunAs(r *RunAs) error {
+	if r.User == "" {
+		return errors.New("user is not set")
+	}
+	u, err := user.Lookup(r.User)
+	if err != nil {
+		return errors.New("unknown user " + r.User)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return errors.New("error while parsing uid " + u.Uid + " of user " + r.User)
+	}
+	r.UID = uint32(uid)
+
+	if r.Group == "" {
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return errors.New("error while parsing gid " + u.Gid + " of user " + r.User)
+		}
+		r.GID = uint32(gid)
+	} else if r.GID, err = lookupGroup(r.Group); err != nil {
+		return err
+	}
+
+	r.Groups = make([]uint32, 0, len(r.SupplementaryGroups))
+	for _, name := range r.SupplementaryGroups {
+		gid, err := lookupGroup(name)
+		if err != nil {
+			return err
+		}
+		r.Groups = append(r.Groups, gid)
+	}
+
+	if os.Geteuid() != 0 &&
+		(int(r.UID) != os.Geteuid() || int(r.GID) != os.Getegid() || len(r.Groups) > 0) {
+		return errors.New("http-cmd must run as root to run commands as user " + r.User)
+	}
+	return nil
+}
 
 // New return a new Config structure, loaded according to a configuration file
 func New(filename string) (*Config, error) {
@@ -276,13 +829,12 @@ func New(filename string) (*Config, error) {
 	if err := loadExecs(&cfg); err != nil {
 		return nil, err
 	}
+	if err := checkGrants(&cfg); err != nil {
+		return nil, err
+	}
 	if err := checkExecNames(&cfg); err != nil {
 		return nil, err
 	}
 
-	// if err := setuid(&cfg); err != nil {
-	// 	return nil, err
-	// }
-
 	return &cfg, nil
 }
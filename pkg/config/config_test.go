@@ -1,12 +1,19 @@
 package config_test
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/etombini/http-cmd/pkg/config"
+	"github.com/etombini/http-cmd/pkg/hangman"
 )
 
 func TestServerConfig(t *testing.T) {
@@ -42,6 +49,24 @@ func TestConfigServerDefault(t *testing.T) {
 	if cfg.Server.ExecPrefix != config.DefaultExecPrefix {
 		t.Error("TestConfigServerDefault: Default server catalog prefix is not "+config.DefaultExecPrefix+": ", cfg.Server.CatalogPrefix)
 	}
+	if cfg.Server.JobsPrefix != config.DefaultJobsPrefix {
+		t.Error("TestConfigServerDefault: Default server jobs prefix is not "+config.DefaultJobsPrefix+": ", cfg.Server.JobsPrefix)
+	}
+	if cfg.Server.StreamPrefix != config.DefaultStreamPrefix {
+		t.Error("TestConfigServerDefault: Default server stream prefix is not "+config.DefaultStreamPrefix+": ", cfg.Server.StreamPrefix)
+	}
+	if cfg.Server.WebsocketPrefix != config.DefaultWebsocketPrefix {
+		t.Error("TestConfigServerDefault: Default server websocket prefix is not "+config.DefaultWebsocketPrefix+": ", cfg.Server.WebsocketPrefix)
+	}
+	if cfg.Server.JobRetention != config.DefaultJobRetention {
+		t.Error("TestConfigServerDefault: Default server job retention is not", config.DefaultJobRetention, ":", cfg.Server.JobRetention)
+	}
+	if cfg.Server.MaxJobs != config.DefaultMaxJobs {
+		t.Error("TestConfigServerDefault: Default server max jobs is not", config.DefaultMaxJobs, ":", cfg.Server.MaxJobs)
+	}
+	if cfg.Server.Shell != config.DefaultShell {
+		t.Error("TestConfigServerDefault: Default server shell is not "+config.DefaultShell+": ", cfg.Server.Shell)
+	}
 
 }
 
@@ -81,3 +106,380 @@ func TestConfigServerPortOutOfBound(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigExecInvalidCommand(t *testing.T) {
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/invalid-command/http-cmd.yaml"
+	_, err := config.New(configFile)
+	if err == nil {
+		t.Error("TestConfigExecInvalidCommand: Missing error for unbalanced quotes")
+		return
+	}
+	if !strings.Contains(err.Error(), "echo") || !strings.Contains(err.Error(), "system.yaml") {
+		t.Error("TestConfigExecInvalidCommand: Error does not name the exec and its file: " + err.Error())
+	}
+}
+
+func TestConfigExecInvalidVariable(t *testing.T) {
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/invalid-variable/http-cmd.yaml"
+	_, err := config.New(configFile)
+	if err == nil {
+		t.Error("TestConfigExecInvalidVariable: Missing error for an unbalanced variable reference")
+		return
+	}
+	if !strings.Contains(err.Error(), "home") || !strings.Contains(err.Error(), "brace") {
+		t.Error("TestConfigExecInvalidVariable: Error does not name the exec and the reference: " + err.Error())
+	}
+}
+
+func TestConfigExecCommandAndArgs(t *testing.T) {
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/command-and-args/http-cmd.yaml"
+	_, err := config.New(configFile)
+	if err == nil {
+		t.Error("TestConfigExecCommandAndArgs: Missing error for exec with both command and args")
+	}
+}
+
+func TestConfigExecKillGrace(t *testing.T) {
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/kill-grace-invalid/http-cmd.yaml"
+	_, err := config.New(configFile)
+	if err == nil {
+		t.Error("TestConfigExecKillGrace: Missing error for kill_grace greater than timeout")
+	}
+}
+
+func TestConfigRunAs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("TestConfigRunAs: running commands as another user requires root")
+	}
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/run-as/http-cmd.yaml"
+	cfg, err := config.New(configFile)
+	if err != nil {
+		t.Error("TestConfigRunAs: Error while creating Config: " + err.Error())
+		return
+	}
+	execs := cfg.Categories[0].Execs
+	if execs[0].RunAs == nil || execs[0].RunAs.User != "nobody" || execs[0].RunAs.UID == 0 {
+		t.Errorf("TestConfigRunAs: Exec %s does not run as the server default user: %+v", execs[0].Name, execs[0].RunAs)
+	}
+	if execs[1].RunAs == nil || execs[1].RunAs.User != "root" || execs[1].RunAs.UID != 0 || len(execs[1].RunAs.Groups) != 1 {
+		t.Errorf("TestConfigRunAs: Exec %s does not run as root: %+v", execs[1].Name, execs[1].RunAs)
+	}
+
+	configFile = os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/run-as-unknown/http-cmd.yaml"
+	if _, err := config.New(configFile); err == nil {
+		t.Error("TestConfigRunAs: Missing error for unknown user")
+	}
+}
+
+func TestConfigExecEnv(t *testing.T) {
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/env/http-cmd.yaml"
+	cfg, err := config.New(configFile)
+	if err != nil {
+		t.Error("TestConfigExecEnv: Error while creating Config: " + err.Error())
+		return
+	}
+	// execs are sorted by name
+	execs := cfg.Categories[0].Execs
+	if !execs[0].InheritEnv.All {
+		t.Errorf("TestConfigExecEnv: Exec %s does not inherit the whole environment: %+v", execs[0].Name, execs[0].InheritEnv)
+	}
+	if execs[1].InheritEnv.All || len(execs[1].InheritEnv.Allowlist) != 2 || execs[1].Env["GREETING"] != "It is working great" {
+		t.Errorf("TestConfigExecEnv: Exec %s does not have an allowlist and variables: %+v", execs[1].Name, execs[1])
+	}
+	if execs[2].InheritEnv.All || !reflect.DeepEqual(execs[2].InheritEnv.Allowlist, config.DefaultInheritEnv) {
+		t.Errorf("TestConfigExecEnv: Exec %s does not inherit the default variables: %+v", execs[2].Name, execs[2].InheritEnv)
+	}
+	if execs[3].InheritEnv.All || execs[3].InheritEnv.Allowlist != nil || execs[3].Workdir != "/" {
+		t.Errorf("TestConfigExecEnv: Exec %s does not inherit an empty environment: %+v", execs[3].Name, execs[3])
+	}
+}
+
+func TestConfigExecParameters(t *testing.T) {
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/parameters/http-cmd.yaml"
+	cfg, err := config.New(configFile)
+	if err != nil {
+		t.Error("TestConfigExecParameters: Error while creating Config: " + err.Error())
+		return
+	}
+	tests := []struct {
+		parameter int
+		value     string
+		valid     bool
+	}{
+		{0, "hello world", true},
+		{0, "-rf", false},
+		{1, "42", true},
+		{1, "42a", false},
+		{1, "-1", false},
+		{2, "::1", true},
+		{2, "example.com", false},
+		{3, "green", true},
+		{3, "blue", false},
+		{4, "XYZ", true},
+		{4, "XYZA", false},
+		{5, "12", true},
+		{5, "-12", false},
+		{6, "-12", true},
+		{6, "-x", false},
+	}
+	for _, test := range tests {
+		p := cfg.Categories[0].Execs[0].Parameters[test.parameter]
+		if err := p.Validate(test.value); (err == nil) != test.valid {
+			t.Errorf("TestConfigExecParameters: Validation of %q for parameter %s is not %t", test.value, p.Name, test.valid)
+		}
+	}
+
+	configFile = os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/parameters-unused/http-cmd.yaml"
+	if _, err := config.New(configFile); err == nil {
+		t.Error("TestConfigExecParameters: Missing error for unused parameter")
+	}
+
+	configFile = os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/parameters-reserved/http-cmd.yaml"
+	if _, err := config.New(configFile); err == nil {
+		t.Error("TestConfigExecParameters: Missing error for reserved parameter name")
+	}
+}
+
+func TestConfigExecMethods(t *testing.T) {
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/methods/http-cmd.yaml"
+	cfg, err := config.New(configFile)
+	if err != nil {
+		t.Error("TestConfigExecMethods: Error while creating Config: " + err.Error())
+		return
+	}
+	execs := cfg.Categories[0].Execs
+	if strings.Join(execs[0].Methods, ",") != "GET,POST" || execs[0].Stdin != config.StdinJSON {
+		t.Errorf("TestConfigExecMethods: Unexpected methods or stdin for exec %s: %v %s", execs[0].Name, execs[0].Methods, execs[0].Stdin)
+	}
+	if strings.Join(execs[1].Methods, ",") != "GET" || execs[1].Stdin != config.StdinNone {
+		t.Errorf("TestConfigExecMethods: Unexpected methods or stdin for exec %s: %v %s", execs[1].Name, execs[1].Methods, execs[1].Stdin)
+	}
+
+	configFile = os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/methods-invalid/http-cmd.yaml"
+	if _, err := config.New(configFile); err == nil {
+		t.Error("TestConfigExecMethods: Missing error for stdin without POST")
+	}
+
+	configFile = os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/async-invalid/http-cmd.yaml"
+	if _, err := config.New(configFile); err == nil {
+		t.Error("TestConfigExecMethods: Missing error for async without POST")
+	}
+
+	configFile = os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/pty-invalid/http-cmd.yaml"
+	if _, err := config.New(configFile); err == nil {
+		t.Error("TestConfigExecMethods: Missing error for pty without websocket")
+	}
+
+	configFile = os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/websocket-invalid/http-cmd.yaml"
+	if _, err := config.New(configFile); err == nil {
+		t.Error("TestConfigExecMethods: Missing error for websocket without GET")
+	}
+}
+
+func TestConfigStatusMapping(t *testing.T) {
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/status-mapping/http-cmd.yaml"
+	cfg, err := config.New(configFile)
+	if err != nil {
+		t.Error("TestConfigStatusMapping: Error while creating Config: " + err.Error())
+		return
+	}
+	tests := []struct {
+		exec   int
+		h      hangman.Harvest
+		status int
+	}{
+		{0, hangman.Harvest{Outcome: hangman.OutcomeSuccess}, 200},
+		{0, hangman.Harvest{Outcome: hangman.OutcomeFailure, ExitCode: 1}, 500},
+		{0, hangman.Harvest{Outcome: hangman.OutcomeTimeout, ExitCode: -1}, 504},
+		{0, hangman.Harvest{Outcome: hangman.OutcomeStartFailure, ExitCode: -1}, 503},
+		{1, hangman.Harvest{Outcome: hangman.OutcomeSuccess}, 200},
+		{1, hangman.Harvest{Outcome: hangman.OutcomeFailure, ExitCode: 1}, 404},
+		{1, hangman.Harvest{Outcome: hangman.OutcomeFailure, ExitCode: 2}, 500},
+		{1, hangman.Harvest{Outcome: hangman.OutcomeTimeout, ExitCode: -1}, 200},
+	}
+	for _, test := range tests {
+		e := cfg.Categories[0].Execs[test.exec]
+		if status := e.StatusMapping.Status(test.h); status != test.status {
+			t.Errorf("TestConfigStatusMapping: Unexpected status %d for exec %s and %+v, expecting %d", status, e.Name, test.h, test.status)
+		}
+	}
+
+	var m *config.StatusMapping
+	if status := m.Status(hangman.Harvest{Outcome: hangman.OutcomeFailure}); status != 200 {
+		t.Errorf("TestConfigStatusMapping: Unexpected status %d without mapping", status)
+	}
+}
+
+func TestConfigExecOutput(t *testing.T) {
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/methods/http-cmd.yaml"
+	cfg, err := config.New(configFile)
+	if err != nil {
+		t.Error("TestConfigExecOutput: Error while creating Config: " + err.Error())
+		return
+	}
+	e := cfg.Categories[0].Execs[0]
+	if e.OutputEncoding != hangman.EncodingUTF8 || e.ContentType != config.DefaultContentType {
+		t.Errorf("TestConfigExecOutput: Unexpected default output encoding or content type: %s %s", e.OutputEncoding, e.ContentType)
+	}
+
+	configFile = os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/output-encoding-invalid/http-cmd.yaml"
+	if _, err := config.New(configFile); err == nil {
+		t.Error("TestConfigExecOutput: Missing error for unknown output encoding")
+	}
+
+	configFile = os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/output-unlimited/http-cmd.yaml"
+	cfg, err = config.New(configFile)
+	if err != nil {
+		t.Error("TestConfigExecOutput: Error while creating Config: " + err.Error())
+		return
+	}
+	if limited := cfg.Categories[0].Execs[0]; limited.MaxOutputBytes != 100 {
+		t.Errorf("TestConfigExecOutput: Max output bytes of %s is not 100: %d", limited.Name, limited.MaxOutputBytes)
+	}
+	if unlimited := cfg.Categories[0].Execs[1]; unlimited.MaxOutputBytes != config.UnlimitedOutputBytes {
+		t.Errorf("TestConfigExecOutput: Max output bytes of %s is not unlimited: %d", unlimited.Name, unlimited.MaxOutputBytes)
+	}
+}
+
+func TestConfigServerTLS(t *testing.T) {
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/tls/http-cmd.yaml"
+	cfg, err := config.New(configFile)
+	if err != nil {
+		t.Error("TestConfigServerTLS: Error while creating Config: " + err.Error())
+		return
+	}
+	if !cfg.Server.TLS.Enabled() || cfg.Server.TLS.MinVersion != tls.VersionTLS13 {
+		t.Errorf("TestConfigServerTLS: Unexpected TLS configuration: %+v", cfg.Server.TLS)
+	}
+	if !strings.HasSuffix(cfg.Server.TLS.Key, "/test-scripts/config/tls/key.pem") {
+		t.Errorf("TestConfigServerTLS: Key path is not relative to the configuration file: %s", cfg.Server.TLS.Key)
+	}
+
+	configFile = os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/tls-invalid/http-cmd.yaml"
+	if _, err := config.New(configFile); err == nil {
+		t.Error("TestConfigServerTLS: Missing error for certificate without key")
+	}
+}
+
+func TestConfigServerAuth(t *testing.T) {
+	dir := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/"
+	cfg, err := config.New(dir + "auth/http-cmd.yaml")
+	if err != nil {
+		t.Error("TestConfigServerAuth: Error while creating Config: " + err.Error())
+		return
+	}
+	if !cfg.Server.TLS.ClientAuth() || cfg.Server.TLS.ClientCAs == nil {
+		t.Error("TestConfigServerAuth: Client authentication is not enabled")
+	}
+	if len(cfg.Server.APIKeys) != 2 {
+		t.Errorf("TestConfigServerAuth: Expected 2 API keys from the configuration and its keys file, got %d", len(cfg.Server.APIKeys))
+	}
+	if !cfg.Server.Htpasswd.Enabled() {
+		t.Error("TestConfigServerAuth: HTTP Basic authentication is not enabled")
+	}
+	if users, err := config.LoadHtpasswd(cfg.Server.Htpasswd.File); err != nil || len(users) != 2 {
+		t.Errorf("TestConfigServerAuth: Expected 2 users from the htpasswd file relative to the configuration file: %d %v", len(users), err)
+	}
+	if len(cfg.Server.HMACKeys) != 2 || cfg.Server.HMACMaxSkew != config.DefaultHMACMaxSkew {
+		t.Errorf("TestConfigServerAuth: Expected 2 HMAC keys with the default max skew, got %d and %d", len(cfg.Server.HMACKeys), cfg.Server.HMACMaxSkew)
+	}
+
+	key, hash, err := config.GenerateAPIKey()
+	if err != nil {
+		t.Fatal("TestConfigServerAuth: " + err.Error())
+	}
+	if config.HashAPIKey(key) != hash {
+		t.Errorf("TestConfigServerAuth: Generated hash %s does not match key %s", hash, key)
+	}
+
+	invalid := []struct {
+		dir    string
+		reason string
+	}{
+		{"api-keys-invalid", "API key stored in clear"},
+		{"htpasswd-invalid", "password not hashed with bcrypt"},
+		{"htpasswd-unknown-user", "group user not in the htpasswd file"},
+		{"hmac-invalid", "short secret"},
+	}
+	for _, test := range invalid {
+		if _, err := config.New(dir + test.dir + "/http-cmd.yaml"); err == nil {
+			t.Error("TestConfigServerAuth: Missing error for " + test.reason)
+		}
+	}
+}
+
+// TestConfigServerGrants checks what the clients of each authentication method
+// are granted, from certificates, API keys, htpasswd users and HMAC keys
+func TestConfigServerGrants(t *testing.T) {
+	dir := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/auth/"
+	cfg, err := config.New(dir + "http-cmd.yaml")
+	if err != nil {
+		t.Error("TestConfigServerGrants: Error while creating Config: " + err.Error())
+		return
+	}
+
+	certGrant := func(file string) *config.Grant {
+		pemBytes, err := ioutil.ReadFile(dir + file)
+		if err != nil {
+			t.Fatal("TestConfigServerGrants: " + err.Error())
+		}
+		block, _ := pem.Decode(pemBytes)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal("TestConfigServerGrants: " + err.Error())
+		}
+		if grant, ok := cfg.Server.TLS.ClientGrant(cert); ok {
+			return grant
+		}
+		return &config.Grant{}
+	}
+	apiKeyGrant := func(key string) *config.Grant {
+		for i := range cfg.Server.APIKeys {
+			if cfg.Server.APIKeys[i].Hash == config.HashAPIKey(key) {
+				return &cfg.Server.APIKeys[i].Grant
+			}
+		}
+		return &config.Grant{}
+	}
+	hmacGrant := func(name string) *config.Grant {
+		for i := range cfg.Server.HMACKeys {
+			if cfg.Server.HMACKeys[i].Name == name {
+				return &cfg.Server.HMACKeys[i].Grant
+			}
+		}
+		return &config.Grant{}
+	}
+
+	tests := []struct {
+		client   string
+		grant    *config.Grant
+		category string
+		exec     string
+		allowed  bool
+	}{
+		{"certificate alice", certGrant("alice.pem"), "system", "uptime", true},
+		{"certificate alice", certGrant("alice.pem"), "network", "hostname", false},
+		{"certificate monitor", certGrant("monitor.pem"), "system", "uptime", false},
+		{"certificate monitor", certGrant("monitor.pem"), "network", "hostname", true},
+		{"certificate monitor", certGrant("monitor.pem"), "network", "interfaces", false},
+		{"API key ci", apiKeyGrant("ci-key"), "system", "uptime", true},
+		{"API key ci", apiKeyGrant("ci-key"), "network", "hostname", false},
+		{"API key monitor", apiKeyGrant("monitor-key"), "network", "hostname", true},
+		{"API key monitor", apiKeyGrant("monitor-key"), "network", "interfaces", false},
+		{"user alice", cfg.Server.Htpasswd.UserGrant("alice"), "system", "uptime", true},
+		{"user alice", cfg.Server.Htpasswd.UserGrant("alice"), "network", "hostname", true},
+		{"user bob", cfg.Server.Htpasswd.UserGrant("bob"), "system", "uptime", false},
+		{"user bob", cfg.Server.Htpasswd.UserGrant("bob"), "network", "hostname", true},
+		{"user bob", cfg.Server.Htpasswd.UserGrant("bob"), "network", "interfaces", false},
+		{"user carol", cfg.Server.Htpasswd.UserGrant("carol"), "network", "hostname", false},
+		{"HMAC key deploy", hmacGrant("deploy"), "system", "uptime", true},
+		{"HMAC key deploy", hmacGrant("deploy"), "network", "hostname", false},
+		{"HMAC key monitor", hmacGrant("monitor"), "network", "hostname", true},
+		{"HMAC key monitor", hmacGrant("monitor"), "system", "uptime", false},
+	}
+	for _, test := range tests {
+		if test.grant.Allows(test.category, test.exec) != test.allowed {
+			t.Errorf("TestConfigServerGrants: %s allowed to run %s/%s is not %t", test.client, test.category, test.exec, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+// GrantAll is a category allowing every category
+const GrantAll string = "*"
+
+// Grant is a structure handling what a client is allowed to run: every exec of
+// Categories, "*" meaning all of them, and each of Execs given as category/exec.
+// A nil Grant allows everything.
+type Grant struct {
+	Categories []string `yaml:"categories"`
+	Execs      []string `yaml:"execs"`
+}
+
+// Allows tells whether exec of category may be run
+func (g *Grant) Allows(category string, exec string) bool {
+	if g == nil {
+		return true
+	}
+	for _, c := range g.Categories {
+		if c == GrantAll || c == category {
+			return true
+		}
+	}
+	for _, e := range g.Execs {
+		if e == category+"/"+exec {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsCategory tells whether at least one exec of category may be run
+func (g *Grant) AllowsCategory(category string) bool {
+	if g == nil {
+		return true
+	}
+	for _, c := range g.Categories {
+		if c == GrantAll || c == category {
+			return true
+		}
+	}
+	for _, e := range g.Execs {
+		if strings.HasPrefix(e, category+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// merge returns a Grant allowing what g and other allow
+func (g *Grant) merge(other *Grant) *Grant {
+	if g == nil || other == nil {
+		return nil
+	}
+	return &Grant{
+		Categories: append(append([]string{}, g.Categories...), other.Categories...),
+		Execs:      append(append([]string{}, g.Execs...), other.Execs...),
+	}
+}
+
+// checkGrant checks the categories and execs of g exist
+func checkGrant(g *Grant, c *Config) error {
+	execs := make(map[string]bool)
+	categories := make(map[string]bool)
+	for i := range c.Categories {
+		categories[c.Categories[i].Name] = true
+		for j := range c.Categories[i].Execs {
+			execs[c.Categories[i].Name+"/"+c.Categories[i].Execs[j].Name] = true
+		}
+	}
+	for _, category := range g.Categories {
+		if category != GrantAll && !categories[category] {
+			return errors.New("unknown category " + category)
+		}
+	}
+	for _, exec := range g.Execs {
+		if !execs[exec] {
+			return errors.New("unknown exec " + exec + ", expecting category/exec")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+)
+
+// HMACMinSecretBytes is the minimum length of the secret shared with a client signing its requests
+const HMACMinSecretBytes int = 32
+
+// HMACKey is a structure handling a secret shared with a client signing its
+// requests with HMAC-SHA256, as done by the client package, and what the
+// client is granted. Name identifies the secret in the signed requests.
+type HMACKey struct {
+	Name   string `yaml:"name"`
+	Secret string `yaml:"secret"`
+	Grant  `yaml:",inline"`
+}
+
+// checkHMACKeys checks the secrets shared with clients signing their requests
+func checkHMACKeys(keys []HMACKey) error {
+	names := make(map[string]bool)
+	for i := range keys {
+		if keys[i].Name == "" {
+			return errors.New("HMAC key name can not be an empty string")
+		}
+		if names[keys[i].Name] {
+			return errors.New("HMAC key duplicate found: " + keys[i].Name)
+		}
+		names[keys[i].Name] = true
+		if len(keys[i].Secret) < HMACMinSecretBytes {
+			return errors.New("HMAC key " + keys[i].Name + " secret must be at least " + strconv.Itoa(HMACMinSecretBytes) + " bytes long")
+		}
+	}
+	return nil
+}
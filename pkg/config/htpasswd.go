@@ -0,0 +1,128 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd is a structure handling HTTP Basic authentication. File is an
+// htpasswd file holding bcrypt hashed passwords, as created by "htpasswd -B",
+// relative paths being relative to the directory of the configuration file.
+// Users are granted what the Groups they belong to are, and nothing when they
+// belong to none.
+type Htpasswd struct {
+	File   string          `yaml:"htpasswd_file"`
+	Groups []HtpasswdGroup `yaml:"htpasswd_groups"`
+}
+
+// HtpasswdGroup is a structure handling what a group of users is granted
+type HtpasswdGroup struct {
+	Name  string   `yaml:"name"`
+	Users []string `yaml:"users"`
+	Grant `yaml:",inline"`
+}
+
+// Enabled tells whether clients must authenticate with a user and a password
+func (h Htpasswd) Enabled() bool {
+	return h.File != ""
+}
+
+// UserGrant returns what user is granted, merging the grants of all the
+// groups it belongs to
+func (h Htpasswd) UserGrant(user string) *Grant {
+	grant := &Grant{}
+	for i := range h.Groups {
+		if contains(h.Groups[i].Users, user) {
+			grant = grant.merge(&h.Groups[i].Grant)
+		}
+	}
+	return grant
+}
+
+// LoadHtpasswd reads the htpasswd file and returns the bcrypt hashed password
+// of each user. Empty lines and lines starting with # are ignored.
+func LoadHtpasswd(file string) (map[string][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, errors.New("line " + strconv.Itoa(n) + " of " + file + " is not user:hash")
+		}
+		user, hash := line[:i], []byte(line[i+1:])
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, errors.New("password of " + user + " in " + file + " is not a bcrypt hash: " + err.Error())
+		}
+		if _, ok := users[user]; ok {
+			return nil, errors.New("user duplicate found in " + file + ": " + user)
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// MissingUsers returns the users of the groups which are not in users, as
+// loaded by LoadHtpasswd
+func (h Htpasswd) MissingUsers(users map[string][]byte) []string {
+	var missing []string
+	for i := range h.Groups {
+		for _, user := range h.Groups[i].Users {
+			if _, ok := users[user]; !ok && !contains(missing, user) {
+				missing = append(missing, user)
+			}
+		}
+	}
+	return missing
+}
+
+// checkHtpasswd checks the HTTP Basic authentication configuration and its
+// htpasswd file, which must hold all the users of the groups
+func checkHtpasswd(h *Htpasswd, configPath string) error {
+	if h.File == "" {
+		if len(h.Groups) != 0 {
+			return errors.New("htpasswd_groups require htpasswd_file")
+		}
+		return nil
+	}
+	if !filepath.IsAbs(h.File) {
+		h.File = filepath.Join(filepath.Dir(configPath), h.File)
+	}
+	users, err := LoadHtpasswd(h.File)
+	if err != nil {
+		return errors.New("can not load htpasswd file " + h.File + ": " + err.Error())
+	}
+
+	names := make(map[string]bool)
+	for i := range h.Groups {
+		if h.Groups[i].Name == "" {
+			return errors.New("htpasswd group name can not be an empty string")
+		}
+		if names[h.Groups[i].Name] {
+			return errors.New("htpasswd group duplicate found: " + h.Groups[i].Name)
+		}
+		names[h.Groups[i].Name] = true
+	}
+	if missing := h.MissingUsers(users); len(missing) != 0 {
+		return errors.New("htpasswd group users not found in " + h.File + ": " + strings.Join(missing, ", "))
+	}
+	return nil
+}
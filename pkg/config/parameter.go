@@ -0,0 +1,168 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/etombini/http-cmd/pkg/hangman"
+)
+
+// Parameter types
+const (
+	// ParameterString accepts any value
+	ParameterString string = "string"
+	// ParameterInt accepts a base 10 integer
+	ParameterInt string = "int"
+	// ParameterEnum accepts one of the values listed in Values
+	ParameterEnum string = "enum"
+	// ParameterHostname accepts a hostname (RFC 1123)
+	ParameterHostname string = "hostname"
+	// ParameterIP accepts an IP (v4 or v6) address
+	ParameterIP string = "ip"
+	// ParameterRegex accepts a value matching entirely Pattern
+	ParameterRegex string = "regex"
+)
+
+var (
+	parameterNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	hostnameLabelRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+)
+
+// Parameter is a structure handling a named parameter of an exec. Its value is
+// given as a query parameter and replaces the arguments of the command which
+// are exactly {name}. A parameter without default is required. Whatever its
+// type, a value starting with a "-" is rejected unless AllowDash is set, so that
+// it can not be taken as an option by the command.
+type Parameter struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Type        string   `yaml:"type"`
+	Default     *string  `yaml:"default"`
+	Values      []string `yaml:"values"`
+	Pattern     string   `yaml:"pattern"`
+	AllowDash   bool     `yaml:"allow_dash"`
+
+	regexp *regexp.Regexp
+}
+
+// Placeholder returns the argument replaced by the value of the parameter
+func (p Parameter) Placeholder() string {
+	return "{" + p.Name + "}"
+}
+
+// Validate checks value is valid according to the parameter type
+func (p Parameter) Validate(value string) error {
+	if !p.AllowDash && strings.HasPrefix(value, "-") {
+		return errors.New("value of parameter " + p.Name + " can not start with a \"-\"")
+	}
+	switch p.Type {
+	case ParameterInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return errors.New("value of parameter " + p.Name + " is not an integer")
+		}
+	case ParameterEnum:
+		for _, v := range p.Values {
+			if v == value {
+				return nil
+			}
+		}
+		return errors.New("value of parameter " + p.Name + " must be one of " + strings.Join(p.Values, ", "))
+	case ParameterHostname:
+		h := strings.TrimSuffix(value, ".")
+		if h == "" || len(h) > 253 {
+			return errors.New("value of parameter " + p.Name + " is not a valid hostname")
+		}
+		for _, label := range strings.Split(h, ".") {
+			if !hostnameLabelRegexp.MatchString(label) {
+				return errors.New("value of parameter " + p.Name + " is not a valid hostname")
+			}
+		}
+	case ParameterIP:
+		if net.ParseIP(value) == nil {
+			return errors.New("value of parameter " + p.Name + " is not a valid IP (v4 or v6) address")
+		}
+	case ParameterRegex:
+		if !p.regexp.MatchString(value) {
+			return errors.New("value of parameter " + p.Name + " does not match " + p.Pattern)
+		}
+	}
+	return nil
+}
+
+// checkParameters checks the parameters declared by an exec and that each of
+// them is used as an argument of its command
+func checkParameters(e *Exec) error {
+	if len(e.Parameters) == 0 {
+		return nil
+	}
+	if e.Shell {
+		return errors.New("parameters can not be used in shell mode")
+	}
+
+	args := e.Args
+	if e.Command != "" {
+		var err error
+		if args, err = hangman.Split(e.Command, nil); err != nil {
+			return err
+		}
+	}
+
+	m := make(map[string]bool)
+	for i := range e.Parameters {
+		p := &e.Parameters[i]
+		if !parameterNameRegexp.MatchString(p.Name) {
+			return errors.New("parameter name \"" + p.Name + "\" must only contain letters, digits, \"_\" and \"-\"")
+		}
+		if p.Name == "format" {
+			return errors.New("parameter name \"format\" is reserved to select the response format")
+		}
+		if m[p.Name] {
+			return errors.New("parameter duplicate found: " + p.Name)
+		}
+		m[p.Name] = true
+
+		switch p.Type {
+		case "":
+			p.Type = ParameterString
+		case ParameterString, ParameterInt, ParameterHostname, ParameterIP:
+		case ParameterEnum:
+			if len(p.Values) == 0 {
+				return errors.New("enum parameter " + p.Name + " has no values")
+			}
+			for _, v := range p.Values {
+				if !p.AllowDash && strings.HasPrefix(v, "-") {
+					return errors.New("enum parameter " + p.Name + " has value " + v + " starting with a \"-\" but allow_dash is not set")
+				}
+			}
+		case ParameterRegex:
+			re, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+			if err != nil {
+				return errors.New("regex parameter " + p.Name + " has an invalid pattern: " + err.Error())
+			}
+			p.regexp = re
+		default:
+			return errors.New("parameter " + p.Name + " has an unknown type " + p.Type)
+		}
+
+		if p.Default != nil {
+			if err := p.Validate(*p.Default); err != nil {
+				return errors.New("invalid default: " + err.Error())
+			}
+		}
+
+		used := false
+		for _, arg := range args {
+			if arg == p.Placeholder() {
+				used = true
+				break
+			}
+		}
+		if !used {
+			return errors.New("parameter " + p.Name + " is not used as an argument (" + p.Placeholder() + ")")
+		}
+	}
+	return nil
+}
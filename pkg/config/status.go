@@ -0,0 +1,110 @@
+package config
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/etombini/http-cmd/pkg/hangman"
+)
+
+// Status mapping presets
+const (
+	// StatusMappingDefault answers 200 whatever the outcome of the execution
+	StatusMappingDefault string = "default"
+	// StatusMappingStrict answers 200 on success, 500 on a non-zero exit code
+	// or a signal, 504 on timeout and 503 on cancellation or start failure
+	StatusMappingStrict string = "strict"
+)
+
+// StatusMapping is a structure handling the HTTP status an execution is answered
+// with, according to its outcome. In yaml, it is either the name of a preset or
+// a mapping, where unset statuses are 200. ExitCodes maps exit codes to statuses,
+// taking precedence over Success and Failure. It applies to the JSON responses
+// of an exec and to the harvest of its jobs. Streamed responses being sent
+// before the execution ends, their status is 200, the mapped status being given
+// in the exit event or message, or in the X-Status trailer.
+type StatusMapping struct {
+	Success      int         `yaml:"success"`
+	Failure      int         `yaml:"failure"`
+	Timeout      int         `yaml:"timeout"`
+	Cancelled    int         `yaml:"cancelled"`
+	StartFailure int         `yaml:"start_failure"`
+	ExitCodes    map[int]int `yaml:"exit_codes"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (m *StatusMapping) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var preset string
+	if err := unmarshal(&preset); err == nil {
+		switch preset {
+		case StatusMappingDefault:
+			*m = StatusMapping{}
+		case StatusMappingStrict:
+			*m = StatusMapping{
+				Success:      http.StatusOK,
+				Failure:      http.StatusInternalServerError,
+				Timeout:      http.StatusGatewayTimeout,
+				Cancelled:    http.StatusServiceUnavailable,
+				StartFailure: http.StatusServiceUnavailable,
+			}
+		default:
+			return errors.New("unknown status_mapping preset " + preset + ", expecting " + StatusMappingDefault + " or " + StatusMappingStrict)
+		}
+		return nil
+	}
+	// statusMapping has no UnmarshalYAML method
+	type statusMapping StatusMapping
+	if err := unmarshal((*statusMapping)(m)); err != nil {
+		return errors.New("status_mapping must be a preset name or a mapping: " + err.Error())
+	}
+	return nil
+}
+
+// Status returns the HTTP status to answer an execution with. A nil mapping answers 200.
+func (m *StatusMapping) Status(h hangman.Harvest) int {
+	if m == nil {
+		return http.StatusOK
+	}
+	switch h.Outcome {
+	case hangman.OutcomeTimeout:
+		return m.Timeout
+	case hangman.OutcomeCancelled:
+		return m.Cancelled
+	case hangman.OutcomeStartFailure:
+		return m.StartFailure
+	}
+	if status, ok := m.ExitCodes[h.ExitCode]; ok && h.ExitCode >= 0 {
+		return status
+	}
+	if h.Outcome == hangman.OutcomeSuccess {
+		return m.Success
+	}
+	return m.Failure
+}
+
+// checkStatusMapping sets the unset statuses of m to 200 and checks all of them are valid
+func checkStatusMapping(m *StatusMapping) error {
+	if m == nil {
+		return nil
+	}
+	statuses := []*int{&m.Success, &m.Failure, &m.Timeout, &m.Cancelled, &m.StartFailure}
+	for code := range m.ExitCodes {
+		if code < 0 || code > 255 {
+			return errors.New("exit code " + strconv.Itoa(code) + " is not in 0-255")
+		}
+		status := m.ExitCodes[code]
+		if status < 200 || status > 599 {
+			return errors.New("status " + strconv.Itoa(status) + " for exit code " + strconv.Itoa(code) + " is not in 200-599")
+		}
+	}
+	for _, status := range statuses {
+		if *status == 0 {
+			*status = http.StatusOK
+		}
+		if *status < 200 || *status > 599 {
+			return errors.New("status " + strconv.Itoa(*status) + " is not in 200-599")
+		}
+	}
+	return nil
+}
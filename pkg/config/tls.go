@@ -0,0 +1,205 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultTLSMinVersion is the default minimum TLS version accepted
+const DefaultTLSMinVersion string = "1.2"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLS is a structure handling the TLS configuration of the server. TLS is
+// enabled when Cert and Key are set, relative paths being relative to the
+// directory of the configuration file. CipherSuites are names as given by
+// crypto/tls, and only apply up to TLS 1.2. When ClientCA is set, clients must
+// present a certificate signed by one of its certificates, and are granted
+// what the Clients matching their certificate are. MinVersion, CipherSuiteIDs
+// and ClientCAs are resolved when the configuration is loaded.
+type TLS struct {
+	Cert          string      `yaml:"tls_cert"`
+	Key           string      `yaml:"tls_key"`
+	MinVersionStr string      `yaml:"tls_min_version"`
+	CipherSuites  []string    `yaml:"tls_cipher_suites"`
+	ClientCA      string      `yaml:"tls_client_ca"`
+	Clients       []TLSClient `yaml:"tls_clients"`
+
+	MinVersion     uint16         `yaml:"-"`
+	CipherSuiteIDs []uint16       `yaml:"-"`
+	ClientCAs      *x509.CertPool `yaml:"-"`
+}
+
+// TLSClient is a structure handling what a client authenticated by its
+// certificate is granted. It matches a certificate when all of its set fields
+// do: CommonName the common name of the subject, DNSName, Email and URI one of
+// the subject alternative names.
+type TLSClient struct {
+	CommonName string `yaml:"common_name"`
+	DNSName    string `yaml:"dns_name"`
+	Email      string `yaml:"email"`
+	URI        string `yaml:"uri"`
+	Grant      `yaml:",inline"`
+}
+
+// Enabled tells whether the server is to be served with TLS
+func (t TLS) Enabled() bool {
+	return t.Cert != ""
+}
+
+// ClientAuth tells whether clients authenticate with a certificate. The
+// certificate is optional when clients can also authenticate with an API key,
+// a password or a signature.
+func (t TLS) ClientAuth() bool {
+	return t.ClientCA != ""
+}
+
+// ClientGrant returns what the client presenting cert is granted, merging the
+// grants of all the matching clients, and false if none of them matches. If no
+// client is configured, any certificate signed by the CA is granted everything.
+func (t TLS) ClientGrant(cert *x509.Certificate) (*Grant, bool) {
+	if len(t.Clients) == 0 {
+		return nil, true
+	}
+	var grant *Grant
+	for i := range t.Clients {
+		if !t.Clients[i].Matches(cert) {
+			continue
+		}
+		if grant == nil {
+			g := t.Clients[i].Grant
+			grant = &g
+		} else {
+			grant = grant.merge(&t.Clients[i].Grant)
+		}
+	}
+	return grant, grant != nil
+}
+
+// Matches tells whether the client presents cert
+func (c TLSClient) Matches(cert *x509.Certificate) bool {
+	if c.CommonName != "" && c.CommonName != cert.Subject.CommonName {
+		return false
+	}
+	if c.DNSName != "" && !contains(cert.DNSNames, c.DNSName) {
+		return false
+	}
+	if c.Email != "" && !contains(cert.EmailAddresses, c.Email) {
+		return false
+	}
+	if c.URI != "" {
+		uris := make([]string, len(cert.URIs))
+		for i := range cert.URIs {
+			uris[i] = cert.URIs[i].String()
+		}
+		if !contains(uris, c.URI) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTLS checks the TLS configuration of the server and loads its certificate
+func checkTLS(t *TLS, configPath string) error {
+	if t.Cert == "" && t.Key == "" {
+		if t.MinVersionStr != "" || len(t.CipherSuites) != 0 || t.ClientCA != "" {
+			return errors.New("tls_min_version, tls_cipher_suites and tls_client_ca require tls_cert and tls_key")
+		}
+		if len(t.Clients) != 0 {
+			return errors.New("tls_clients require tls_client_ca")
+		}
+		return nil
+	}
+	if t.Cert == "" || t.Key == "" {
+		return errors.New("tls_cert and tls_key must be set together")
+	}
+
+	dir := filepath.Dir(configPath)
+	if !filepath.IsAbs(t.Cert) {
+		t.Cert = filepath.Join(dir, t.Cert)
+	}
+	if !filepath.IsAbs(t.Key) {
+		t.Key = filepath.Join(dir, t.Key)
+	}
+	if _, err := tls.LoadX509KeyPair(t.Cert, t.Key); err != nil {
+		return errors.New("can not load certificate " + t.Cert + " and key " + t.Key + ": " + err.Error())
+	}
+
+	if t.MinVersionStr == "" {
+		t.MinVersionStr = DefaultTLSMinVersion
+	}
+	version, ok := tlsVersions[t.MinVersionStr]
+	if !ok {
+		return errors.New("unknown tls_min_version " + t.MinVersionStr + ", expecting 1.0, 1.1, 1.2 or 1.3")
+	}
+	t.MinVersion = version
+
+	suites := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		suites[s.Name] = s.ID
+	}
+	insecure := make(map[string]bool)
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+	t.CipherSuiteIDs = nil
+	for _, name := range t.CipherSuites {
+		id, ok := suites[name]
+		if insecure[name] {
+			return errors.New("cipher suite " + name + " is insecure")
+		}
+		if !ok {
+			names := make([]string, 0, len(suites))
+			for n := range suites {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return errors.New("unknown cipher suite " + name + ", expecting one of " + strings.Join(names, ", "))
+		}
+		t.CipherSuiteIDs = append(t.CipherSuiteIDs, id)
+	}
+
+	if t.ClientCA == "" {
+		if len(t.Clients) != 0 {
+			return errors.New("tls_clients require tls_client_ca")
+		}
+		return nil
+	}
+	if !filepath.IsAbs(t.ClientCA) {
+		t.ClientCA = filepath.Join(dir, t.ClientCA)
+	}
+	pem, err := ioutil.ReadFile(t.ClientCA)
+	if err != nil {
+		return errors.New("can not read client CA " + t.ClientCA + ": " + err.Error())
+	}
+	t.ClientCAs = x509.NewCertPool()
+	if !t.ClientCAs.AppendCertsFromPEM(pem) {
+		return errors.New("no certificate found in client CA " + t.ClientCA)
+	}
+	for i := range t.Clients {
+		c := t.Clients[i]
+		if c.CommonName == "" && c.DNSName == "" && c.Email == "" && c.URI == "" {
+			return errors.New("tls client must have at least one of common_name, dns_name, email and uri")
+		}
+	}
+	return nil
+}
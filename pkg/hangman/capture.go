@@ -0,0 +1,76 @@
+package hangman
+
+// capture is an io.Writer keeping at most limit bytes of what is written to it,
+// while counting every byte. By default, the first limit bytes are kept. When
+// keepTail is set, the first and the last limit/2 bytes are kept instead, so
+// that the end of a long output is not lost. A limit of 0 or less means no limit.
+type capture struct {
+	limit    int64
+	keepTail bool
+	total    int64
+	head     []byte
+	tail     []byte
+}
+
+func newCapture(limit int64, keepTail bool) *capture {
+	return &capture{limit: limit, keepTail: keepTail}
+}
+
+func (c *capture) headLimit() int64 {
+	if c.keepTail {
+		return c.limit - c.limit/2
+	}
+	return c.limit
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	n := len(p)
+	c.total += int64(n)
+	if c.limit <= 0 {
+		c.head = append(c.head, p...)
+		return n, nil
+	}
+
+	if room := c.headLimit() - int64(len(c.head)); room > 0 {
+		if int64(len(p)) <= room {
+			c.head = append(c.head, p...)
+			return n, nil
+		}
+		c.head = append(c.head, p[:room]...)
+		p = p[room:]
+	}
+	if !c.keepTail {
+		return n, nil
+	}
+
+	tailLimit := int(c.limit / 2)
+	if len(p) > tailLimit {
+		p = p[len(p)-tailLimit:]
+	}
+	c.tail = append(c.tail, p...)
+	if len(c.tail) > tailLimit {
+		c.tail = c.tail[len(c.tail)-tailLimit:]
+	}
+	return n, nil
+}
+
+// Truncated tells whether some of the output has been dropped
+func (c *capture) Truncated() bool {
+	return c.total > int64(len(c.head)+len(c.tail))
+}
+
+// Total returns the number of bytes written, including the dropped ones
+func (c *capture) Total() int64 {
+	return c.total
+}
+
+// Bytes returns the kept output. If some of it has been dropped, the kept
+// beginning and end are put together as is, Truncated telling it happened, so
+// that binary outputs are not corrupted.
+func (c *capture) Bytes() []byte {
+	return append(c.head, c.tail...)
+}
+
+func (c *capture) String() string {
+	return string(c.Bytes())
+}
@@ -0,0 +1,30 @@
+package hangman
+
+import (
+	"encoding/base64"
+	"unicode/utf8"
+)
+
+// Encodings of the outputs in a Harvest
+const (
+	// EncodingUTF8 gives outputs as text, invalid UTF-8 sequences being replaced
+	// when the Harvest is converted to JSON
+	EncodingUTF8 string = "utf8"
+	// EncodingBase64 gives outputs encoded in standard base64
+	EncodingBase64 string = "base64"
+	// EncodingAuto gives outputs as text if they are valid UTF-8, in base64 otherwise
+	EncodingAuto string = "auto"
+)
+
+// encode returns b encoded as requested, and the encoding used
+func encode(b []byte, encoding string) (string, string) {
+	switch encoding {
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(b), EncodingBase64
+	case EncodingAuto:
+		if !utf8.Valid(b) {
+			return base64.StdEncoding.EncodeToString(b), EncodingBase64
+		}
+	}
+	return string(b), EncodingUTF8
+}
@@ -1,58 +1,282 @@
 package hangman
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
-// Harvest is the result of an execution done by the function Reaper
+// Harvest is the result of an execution done by the function Reaper.
+// IOError reports an error while feeding the standard input or reading the outputs.
+// If the standard input can not be read, the process is terminated as when the
+// execution is cancelled.
+// Outcome sums up how the execution ended, as one of the Outcome* values.
+// ExitCode is -1 when the process did not exit normally, in which case Signal
+// holds the name of the signal which terminated it. StartError is set to one of
+// the StartError* kinds when the process could not be started at all.
+// ReturnCode is kept for compatibility and holds the same value as ExitCode.
+// StdoutEncoding and StderrEncoding tell how Stdout and Stderr are encoded, as
+// one of the Encoding* values.
 type Harvest struct {
-	OriginalCommand string `json:"orignal_command"`
-	ExecutedCommand string `json:"executed_command"`
-	ReturnCode      int    `json:"return_code"`
-	TimeoutReached  bool   `json:"timeout_reached"`
-	Pid             int    `json:"pid"`
-	Stdout          string `json:"stdout"`
-	Stderr          string `json:"stderr"`
+	OriginalCommand string   `json:"orignal_command"`
+	ExecutedCommand string   `json:"executed_command"`
+	Outcome         string   `json:"outcome"`
+	ReturnCode      int      `json:"return_code"`
+	ExitCode        int      `json:"exit_code"`
+	Signal          string   `json:"signal,omitempty"`
+	CoreDumped      bool     `json:"core_dumped"`
+	StartError      string   `json:"start_error,omitempty"`
+	TimeoutReached  bool     `json:"timeout_reached"`
+	Pid             int      `json:"pid"`
+	Interpreter     string   `json:"interpreter,omitempty"`
+	KillSignal      string   `json:"kill_signal,omitempty"`
+	Args            []string `json:"args"`
+	IOError         string   `json:"io_error,omitempty"`
+	Stdout          string   `json:"stdout"`
+	Stderr          string   `json:"stderr"`
+	StdoutEncoding  string   `json:"stdout_encoding"`
+	StderrEncoding  string   `json:"stderr_encoding"`
+	StdoutTruncated bool     `json:"stdout_truncated"`
+	StderrTruncated bool     `json:"stderr_truncated"`
+	StdoutBytes     int64    `json:"stdout_bytes"`
+	StderrBytes     int64    `json:"stderr_bytes"`
+
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	Duration   float64   `json:"duration_seconds"`
+	UserTime   float64   `json:"user_time_seconds"`
+	SystemTime float64   `json:"system_time_seconds"`
+	MaxRSS     int64     `json:"max_rss_kb"`
+}
+
+// Sentence describes a command to be executed by the function Reap
+type Sentence struct {
+	// Command is a command line, split and expanded before execution
+	Command string
+	// Args is a list of arguments executed verbatim, used instead of Command when set
+	Args []string
+	// Shell is the path of the shell running Command, if Command is to be run through a shell
+	Shell   string
+	Timeout uint32
+	// KillGrace is the delay in seconds between SIGTERM and SIGKILL when the timeout is reached.
+	// If zero, the process group is killed right away
+	KillGrace uint32
+	// MaxOutputBytes is the maximum number of bytes kept from stdout and from stderr,
+	// 0 or less meaning no limit. When KeepTail is set, both the beginning and the end
+	// of the output are kept, otherwise only the beginning is
+	MaxOutputBytes int64
+	KeepTail       bool
+	// OutputEncoding is how outputs are encoded in the Harvest, one of the
+	// Encoding* values, EncodingUTF8 if empty
+	OutputEncoding string
+	// Limits are the resource limits applied to the process
+	Limits Limits
+	// Credential is the account the process is run as, nil meaning the current one
+	Credential *Credential
+	// Dir is the working directory of the process, empty meaning the current one
+	Dir string
+	// Env is the environment of the process, also used to expand variables in
+	// Command. If nil, the current environment is used
+	Env []string
+	// Parameters are values replacing the arguments which are exactly {name},
+	// name being the key. They are neither split nor expanded.
+	Parameters map[string]string
+	// Stdin is read as the standard input of the process, if not nil
+	Stdin io.Reader
+	// Stdout and Stderr, if not nil, are given the outputs of the process as soon
+	// as they are written, on top of being captured in the Harvest
+	Stdout io.Writer
+	Stderr io.Writer
+	// Terminal, if not nil, runs the process in a new session with a pseudo-terminal
+	// as its controlling terminal, standard input and outputs. Stderr is then merged
+	// into stdout.
+	Terminal *Terminal
+}
+
+// waitDelay is how long the outputs are still read once the process has exited
+// or been killed. They are then closed, even if descendants of the process which
+// left its group still hold them.
+const waitDelay = 2 * time.Second
+
+// MaxDuration returns how long running s may take at most: its timeout, the
+// kill grace given to the process group, then the time its outputs are still
+// read once the process is killed
+func (s Sentence) MaxDuration() time.Duration {
+	return time.Duration(s.Timeout+s.KillGrace)*time.Second + waitDelay
+}
+
+// Credential holds the user and groups ids a process is run as
+type Credential struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32
 }
 
 // Reaper execute a program with is parameters as a string, with a timeout limiting execution time
 func Reaper(cmdline string, timeout uint32) Harvest {
-	//cmdline = "sh -c " + cmdline
-	expandedCmdline := os.ExpandEnv(cmdline)
-	cmdSplit := strings.Split(strings.TrimSpace(expandedCmdline), " ")
+	return Reap(Sentence{Command: cmdline, Timeout: timeout})
+}
 
-	var cmd *exec.Cmd
-	if len(cmdSplit) > 1 {
-		cmd = exec.Command(cmdSplit[0], cmdSplit[1:]...)
-	} else {
-		cmd = exec.Command(cmdSplit[0])
-	}
+// ReaperContext is like Reaper, the program being terminated when ctx is done
+func ReaperContext(ctx context.Context, cmdline string, timeout uint32) Harvest {
+	return ReapContext(ctx, Sentence{Command: cmdline, Timeout: timeout})
+}
+
+// Reap execute the program described by a Sentence, with a timeout limiting execution time
+func Reap(s Sentence) Harvest {
+	return ReapContext(context.Background(), s)
+}
 
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// ReapContext is like Reap, the process group being terminated the same way as
+// when the timeout is reached when ctx is done
+func ReapContext(ctx context.Context, s Sentence) Harvest {
+	return ReapStream(ctx, s, nil)
+}
 
+// ReapStream is like ReapContext, out being called for each line written by the
+// process on stdout or stderr as soon as it is written. Calls to out are not
+// concurrent and all of them are done when ReapStream returns. The outputs are
+// still captured in the Harvest.
+func ReapStream(ctx context.Context, s Sentence, out func(Line)) Harvest {
 	var h Harvest
-	h.ExecutedCommand = expandedCmdline
-	h.OriginalCommand = cmdline
 
-	if err := cmd.Start(); err != nil {
+	env := s.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	var args []string
+	var err error
+	if len(s.Args) > 0 {
+		h.OriginalCommand = join(s.Args)
+		args = s.Args
+	} else if s.Shell != "" {
+		h.OriginalCommand = s.Command
+		h.Interpreter = s.Shell
+		args = []string{s.Shell, "-c", s.Command}
+	} else {
+		h.OriginalCommand = s.Command
+		args, err = Split(s.Command, lookupEnv(env))
+		if err == nil && len(args) == 0 {
+			err = errors.New("empty command line")
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can not parse command %s:  %v\n", h.OriginalCommand, err)
+		h.setStartError(StartErrorInvalid, err)
+		return h
+	}
+	args = substitute(args, s.Parameters)
+	h.ExecutedCommand = join(args)
+	h.Args = args
+
+	path, err := lookPath(args[0], env)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Can not execute command %s:  %v\n", h.ExecutedCommand, err)
-		h.Pid = -1
-		h.ReturnCode = 666
-		h.TimeoutReached = false
-		h.Stderr = err.Error()
+		h.setStartError(startErrorKind(err), err)
+		return h
+	}
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Args[0] = args[0]
+
+	cmd.Dir = s.Dir
+	cmd.Env = env
+	var stdin *stdinPipe
+	if s.Stdin != nil && s.Terminal == nil {
+		if stdin, err = newStdinPipe(cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "Can not open the standard input of command %s:  %v\n", h.ExecutedCommand, err)
+			h.setStartError(StartErrorOther, err)
+			return h
+		}
+	}
 
+	stdout := newCapture(s.MaxOutputBytes, s.KeepTail)
+	stderr := newCapture(s.MaxOutputBytes, s.KeepTail)
+	stdoutWriters := []io.Writer{stdout}
+	stderrWriters := []io.Writer{stderr}
+	if s.Stdout != nil {
+		stdoutWriters = append(stdoutWriters, s.Stdout)
+	}
+	if s.Stderr != nil {
+		stderrWriters = append(stderrWriters, s.Stderr)
+	}
+	flush := func() {}
+	if out != nil {
+		mu := new(sync.Mutex)
+		stdoutLines := &lineWriter{stream: StreamStdout, out: out, mu: mu}
+		stderrLines := &lineWriter{stream: StreamStderr, out: out, mu: mu}
+		stdoutWriters = append(stdoutWriters, stdoutLines)
+		stderrWriters = append(stderrWriters, stderrLines)
+		flush = func() {
+			stdoutLines.flush()
+			stderrLines.flush()
+		}
+	}
+	cmd.Stdout = io.MultiWriter(stdoutWriters...)
+	cmd.Stderr = io.MultiWriter(stderrWriters...)
+	// descendants may keep the outputs open once the process has exited or been killed
+	cmd.WaitDelay = waitDelay
+	// run in a dedicated process group, so that children can be killed altogether
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if s.Credential != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    s.Credential.UID,
+			Gid:    s.Credential.GID,
+			Groups: s.Credential.Groups,
+			// only root can set supplementary groups
+			NoSetGroups: os.Geteuid() != 0,
+		}
+	}
+
+	if ctx.Err() != nil {
+		h.setCancelled()
 		return h
 	}
 
+	var term *terminal
+	if s.Terminal != nil {
+		if term, err = newTerminal(cmd, s.Terminal); err != nil {
+			fmt.Fprintf(os.Stderr, "Can not open a terminal for command %s:  %v\n", h.ExecutedCommand, err)
+			h.setStartError(StartErrorOther, err)
+			return h
+		}
+	}
+
+	h.StartTime = time.Now()
+	if s.Limits.Empty() {
+		err = cmd.Start()
+	} else {
+		err = startLimited(cmd, s.Limits)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can not execute command %s:  %v\n", h.ExecutedCommand, err)
+		h.setStartError(startErrorKind(err), err)
+		h.setEndTime(time.Now())
+		if term != nil {
+			term.close()
+		}
+		if stdin != nil {
+			stdin.close()
+		}
+		return h
+	}
+	if stdin != nil {
+		stdin.start(s.Stdin)
+		defer stdin.close()
+	}
+	if term != nil {
+		// stderr is merged into stdout by the terminal
+		term.start(s.Stdin, io.MultiWriter(stdoutWriters...), s.Terminal.Resize)
+	}
+
 	h.Pid = cmd.Process.Pid
 	h.ReturnCode = 0
 	h.TimeoutReached = false
@@ -60,33 +284,160 @@ func Reaper(cmdline string, timeout uint32) Harvest {
 	done := make(chan error, 1)
 
 	go func() {
-		done <- cmd.Wait()
+		err := cmd.Wait()
+		if term != nil {
+			term.wait()
+		}
+		done <- err
 		close(done)
 	}()
 
 	select {
-	case <-time.After(time.Duration(timeout) * time.Second):
-		if err := cmd.Process.Kill(); err != nil {
-			fmt.Println("Some error happened when trying to kill the process")
-		}
-		h.ReturnCode = 127
+	case <-time.After(time.Duration(s.Timeout) * time.Second):
+		h.KillSignal, _ = terminate(cmd, done, s.KillGrace)
+		h.setEndTime(time.Now())
+		h.setStatus(cmd.ProcessState)
 		h.TimeoutReached = true
-		h.Stderr = stderr.String()
-		h.Stdout = stdout.String()
+		h.Outcome = OutcomeTimeout
+		flush()
+		h.setOutput(stdout, stderr, s.OutputEncoding)
+		return h
+
+	case <-ctx.Done():
+		h.KillSignal, _ = terminate(cmd, done, s.KillGrace)
+		h.setEndTime(time.Now())
+		h.setStatus(cmd.ProcessState)
+		h.Outcome = OutcomeCancelled
+		flush()
+		h.setOutput(stdout, stderr, s.OutputEncoding)
+		return h
+
+	case err := <-stdin.errors():
+		// the process is not left to run on a partial standard input
+		fmt.Fprintf(os.Stderr, "Command \"%s\" standard input could not be read: %s\n", h.ExecutedCommand, err.Error())
+		h.KillSignal, _ = terminate(cmd, done, s.KillGrace)
+		h.setEndTime(time.Now())
+		h.setStatus(cmd.ProcessState)
+		h.Outcome = OutcomeCancelled
+		h.IOError = err.Error()
+		flush()
+		h.setOutput(stdout, stderr, s.OutputEncoding)
 		return h
 
 	case err := <-done:
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Command \"%s\" returned an error: %s\n", h.ExecutedCommand, err.Error())
-			if strings.HasPrefix(err.Error(), "exit status") {
-				h.ReturnCode, _ = strconv.Atoi(err.Error()[12:])
-			} else {
-				h.ReturnCode = 666
+			// other errors happen while copying outputs
+			if _, ok := err.(*exec.ExitError); !ok {
+				h.IOError = err.Error()
 			}
 		}
-
-		h.Stderr = stderr.String()
-		h.Stdout = stdout.String()
+		// the process may have exited before its standard input failed
+		select {
+		case err := <-stdin.errors():
+			if h.IOError == "" {
+				fmt.Fprintf(os.Stderr, "Command \"%s\" standard input could not be read: %s\n", h.ExecutedCommand, err.Error())
+				h.IOError = err.Error()
+			}
+		default:
+		}
+		h.setEndTime(time.Now())
+		h.setStatus(cmd.ProcessState)
+		flush()
+		h.setOutput(stdout, stderr, s.OutputEncoding)
 		return h
 	}
 }
+
+// substitute returns a copy of args where arguments which are exactly {name}
+// are replaced by parameters[name]
+func substitute(args []string, parameters map[string]string) []string {
+	if len(parameters) == 0 {
+		return args
+	}
+	subst := make([]string, len(args))
+	for i, arg := range args {
+		subst[i] = arg
+		if strings.HasPrefix(arg, "{") && strings.HasSuffix(arg, "}") {
+			if value, ok := parameters[arg[1:len(arg)-1]]; ok {
+				subst[i] = value
+			}
+		}
+	}
+	return subst
+}
+
+// lookupEnv returns a function giving the value of a variable in env
+func lookupEnv(env []string) func(string) string {
+	return func(name string) string {
+		for i := len(env) - 1; i >= 0; i-- {
+			if strings.HasPrefix(env[i], name+"=") {
+				return env[i][len(name)+1:]
+			}
+		}
+		return ""
+	}
+}
+
+// defaultPath is searched for programs when the environment has no PATH, as execvp(3) does
+const defaultPath = "/usr/local/bin:/usr/bin:/bin"
+
+// lookPath searches the program file in the directories of the PATH of env, as
+// exec.LookPath does with the current environment. Relative directories are
+// ignored, and file is returned as is if it contains a slash.
+func lookPath(file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	dirs := lookupEnv(env)("PATH")
+	if dirs == "" {
+		dirs = defaultPath
+	}
+	for _, dir := range filepath.SplitList(dirs) {
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		path := filepath.Join(dir, file)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
+}
+
+// setOutput records in h what has been captured from the process outputs, encoded as requested
+func (h *Harvest) setOutput(stdout *capture, stderr *capture, encoding string) {
+	h.Stdout, h.StdoutEncoding = encode(stdout.Bytes(), encoding)
+	h.StdoutTruncated = stdout.Truncated()
+	h.StdoutBytes = stdout.Total()
+	h.Stderr, h.StderrEncoding = encode(stderr.Bytes(), encoding)
+	h.StderrTruncated = stderr.Truncated()
+	h.StderrBytes = stderr.Total()
+}
+
+// terminate ends the process group of cmd once the timeout is reached or the execution cancelled. If grace is
+// not zero, the group is first sent a SIGTERM and given grace seconds to exit. The
+// group is then sent a SIGKILL, even if the process exited on SIGTERM, so that
+// descendants ignoring or outliving it are killed too. It returns the name of the
+// signal which ended the process and the error returned by cmd.Wait through done.
+func terminate(cmd *exec.Cmd, done <-chan error, grace uint32) (string, error) {
+	pgid := -cmd.Process.Pid
+	if grace > 0 {
+		if err := syscall.Kill(pgid, syscall.SIGTERM); err != nil {
+			fmt.Fprintf(os.Stderr, "Can not send SIGTERM to process group %d: %v\n", cmd.Process.Pid, err)
+		}
+		select {
+		case err := <-done:
+			// the group is gone if no descendant is left
+			if err := syscall.Kill(pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				fmt.Fprintf(os.Stderr, "Can not send SIGKILL to process group %d: %v\n", cmd.Process.Pid, err)
+			}
+			return signalName(syscall.SIGTERM), err
+		case <-time.After(time.Duration(grace) * time.Second):
+		}
+	}
+	if err := syscall.Kill(pgid, syscall.SIGKILL); err != nil {
+		fmt.Fprintf(os.Stderr, "Can not send SIGKILL to process group %d: %v\n", cmd.Process.Pid, err)
+	}
+	return signalName(syscall.SIGKILL), <-done
+}
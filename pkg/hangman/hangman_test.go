@@ -1,18 +1,28 @@
 package hangman_test
 
 import (
-	"github.com/etombini/http-cmd/pkg/hangman"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/hangman"
 )
 
 func TestLs(t *testing.T) {
 	cmdline := "ls -la"
 	h := hangman.Reaper(cmdline, 1)
-	if h.Return_code != 0 {
-		t.Error("Return code is not 0: ", h.Return_code)
+	if h.ReturnCode != 0 {
+		t.Error("Return code is not 0: ", h.ReturnCode)
 	}
-	if h.Timeout_reached == true {
-		t.Error("Timeout has been reached: ", h.Timeout_reached)
+	if h.TimeoutReached == true {
+		t.Error("Timeout has been reached: ", h.TimeoutReached)
 	}
 	if h.Stderr != "" {
 		t.Error("There are errors on stderr")
@@ -25,10 +35,444 @@ func TestLs(t *testing.T) {
 func TestOverTime(t *testing.T) {
 	cmdline := "sleep 2"
 	h := hangman.Reaper(cmdline, 1)
-	if h.Return_code == 0 {
-		t.Error("Return code is zero: ", h.Return_code)
+	if h.ReturnCode == 0 {
+		t.Error("Return code is zero: ", h.ReturnCode)
+	}
+	if h.TimeoutReached == false {
+		t.Error("Timeout has not been reached: ", h.TimeoutReached)
+	}
+	if h.Signal != "SIGKILL" {
+		t.Error("Signal is not SIGKILL: ", h.Signal)
+	}
+	if h.Outcome != hangman.OutcomeTimeout {
+		t.Error("Outcome is not "+hangman.OutcomeTimeout+": ", h.Outcome)
+	}
+}
+
+func TestQuotes(t *testing.T) {
+	cmdline := `echo "It is   working"  'great \n'`
+	h := hangman.Reaper(cmdline, 1)
+	if h.ReturnCode != 0 {
+		t.Error("Return code is not 0: ", h.ReturnCode)
+	}
+	if h.Stdout != "It is   working great \\n\n" {
+		t.Errorf("Unexpected output on stdout: %q", h.Stdout)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	mapping := func(name string) string {
+		if name == "HOST" {
+			return "example.com local"
+		}
+		return ""
+	}
+	tests := []struct {
+		cmdline string
+		args    []string
+	}{
+		{"ls -la", []string{"ls", "-la"}},
+		{"  ls   -la  ", []string{"ls", "-la"}},
+		{`echo "It is working great"`, []string{"echo", "It is working great"}},
+		{`echo 'a "b" c' d\ e`, []string{"echo", `a "b" c`, "d e"}},
+		{`echo "a \"b\" \c"`, []string{"echo", `a "b" \c`}},
+		{`echo '' ""`, []string{"echo", "", ""}},
+		{`ping $HOST`, []string{"ping", "example.com local"}},
+		{`ping "${HOST}" '$HOST'`, []string{"ping", "example.com local", "$HOST"}},
+		{`echo $ \$HOST`, []string{"echo", "$", "$HOST"}},
+	}
+	for _, test := range tests {
+		args, err := hangman.Split(test.cmdline, mapping)
+		if err != nil {
+			t.Errorf("Split(%q) returned an error: %s", test.cmdline, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Split(%q) = %q, expecting %q", test.cmdline, args, test.args)
+		}
+	}
+
+	for _, cmdline := range []string{`echo "abc`, `echo 'abc`, `echo abc\`, `echo ${HOST`} {
+		if _, err := hangman.Split(cmdline, mapping); err == nil {
+			t.Errorf("Split(%q) did not return an error", cmdline)
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	s := hangman.Sentence{Args: []string{"echo", "It is  working", "$HOME"}, Timeout: 1}
+	h := hangman.Reap(s)
+	if h.ReturnCode != 0 {
+		t.Error("Return code is not 0: ", h.ReturnCode)
+	}
+	if h.Stdout != "It is  working $HOME\n" {
+		t.Errorf("Unexpected output on stdout: %q", h.Stdout)
+	}
+}
+
+func TestShell(t *testing.T) {
+	s := hangman.Sentence{Command: "echo working | tr a-z A-Z", Shell: "/bin/sh", Timeout: 1}
+	h := hangman.Reap(s)
+	if h.ReturnCode != 0 {
+		t.Error("Return code is not 0: ", h.ReturnCode)
+	}
+	if h.Interpreter != "/bin/sh" {
+		t.Error("Interpreter is not /bin/sh: ", h.Interpreter)
+	}
+	if h.Stdout != "WORKING\n" {
+		t.Errorf("Unexpected output on stdout: %q", h.Stdout)
+	}
+}
+
+func TestOverTimeChildren(t *testing.T) {
+	s := hangman.Sentence{Command: "sleep 10 & sleep 10", Shell: "/bin/sh", Timeout: 1}
+	start := time.Now()
+	h := hangman.Reap(s)
+	if time.Since(start) > 5*time.Second {
+		t.Error("Children have not been killed on timeout: ", time.Since(start))
+	}
+	if h.TimeoutReached == false {
+		t.Error("Timeout has not been reached: ", h.TimeoutReached)
+	}
+	if h.KillSignal != "SIGKILL" {
+		t.Error("Process has not been killed with SIGKILL: ", h.KillSignal)
+	}
+}
+
+func TestOverTimeGrace(t *testing.T) {
+	s := hangman.Sentence{Command: "trap 'exit 3' TERM; sleep 10 & wait", Shell: "/bin/sh", Timeout: 1, KillGrace: 3}
+	h := hangman.Reap(s)
+	if h.TimeoutReached == false {
+		t.Error("Timeout has not been reached: ", h.TimeoutReached)
+	}
+	if h.KillSignal != "SIGTERM" {
+		t.Error("Process has not been ended with SIGTERM: ", h.KillSignal)
+	}
+}
+
+func TestOverTimeGraceChildren(t *testing.T) {
+	// the child ignores SIGTERM, it is killed once its parent has exited
+	s := hangman.Sentence{Command: "(trap '' TERM; exec sleep 10) >/dev/null & echo $!; sleep 10", Shell: "/bin/sh", Timeout: 1, KillGrace: 3}
+	h := hangman.Reap(s)
+	if h.KillSignal != "SIGTERM" {
+		t.Error("Process has not been ended with SIGTERM: ", h.KillSignal)
+	}
+	pid := strings.TrimSpace(h.Stdout)
+	for deadline := time.Now().Add(time.Second); ; {
+		// the killed child may be left a zombie if nothing reaps it
+		stat, err := ioutil.ReadFile("/proc/" + pid + "/stat")
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Error("Child ignoring SIGTERM is still running: ", string(stat))
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	s := hangman.Sentence{Command: "trap '' TERM; sleep 10", Shell: "/bin/sh", Timeout: 1, KillGrace: 2}
+	start := time.Now()
+	hangman.Reap(s)
+	if d := time.Since(start); d > s.MaxDuration() {
+		t.Errorf("Execution took %s, more than its max duration %s", d, s.MaxDuration())
+	}
+	if s.MaxDuration() < 3*time.Second {
+		t.Error("Max duration does not include the kill grace: ", s.MaxDuration())
+	}
+}
+
+func TestOverTimeEscaped(t *testing.T) {
+	// the child leaves the process group, it is not killed but keeps stdout open
+	s := hangman.Sentence{Command: "setsid sleep 6 & sleep 10", Shell: "/bin/sh", Timeout: 1}
+	h := hangman.Reap(s)
+	if h.TimeoutReached == false {
+		t.Error("Timeout has not been reached: ", h.TimeoutReached)
+	}
+	if h.Duration > 4 {
+		t.Error("Outputs held by an escaped child have been waited for: ", h.Duration)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	{
+		h := hangman.Reap(hangman.Sentence{Command: "exit 3", Shell: "/bin/sh", Timeout: 1})
+		if h.ExitCode != 3 || h.ReturnCode != 3 {
+			t.Error("Exit code is not 3: ", h.ExitCode, h.ReturnCode)
+		}
+		if h.Signal != "" || h.StartError != "" {
+			t.Error("Unexpected signal or start error: ", h.Signal, h.StartError)
+		}
+	}
+	{
+		h := hangman.Reap(hangman.Sentence{Command: "kill -USR1 $$", Shell: "/bin/sh", Timeout: 1})
+		if h.ExitCode != -1 {
+			t.Error("Exit code is not -1: ", h.ExitCode)
+		}
+		if h.Signal != "SIGUSR1" {
+			t.Error("Signal is not SIGUSR1: ", h.Signal)
+		}
+	}
+	{
+		h := hangman.Reaper("/nonexistent/command", 1)
+		if h.StartError != hangman.StartErrorNotFound {
+			t.Error("Start error is not "+hangman.StartErrorNotFound+": ", h.StartError)
+		}
+		if h.Pid != -1 || h.ExitCode != -1 {
+			t.Error("Pid and exit code are not -1: ", h.Pid, h.ExitCode)
+		}
+	}
+}
+
+func TestOutputLimit(t *testing.T) {
+	{
+		h := hangman.Reap(hangman.Sentence{Command: "seq 1 10000", Timeout: 1, MaxOutputBytes: 100})
+		if !h.StdoutTruncated {
+			t.Error("Stdout has not been truncated")
+		}
+		if h.StdoutBytes != 48894 {
+			t.Error("Stdout byte count is not 48894: ", h.StdoutBytes)
+		}
+		if len(h.Stdout) != 100 || !strings.HasPrefix(h.Stdout, "1\n2\n3\n") {
+			t.Errorf("Unexpected truncated output on stdout: %q", h.Stdout)
+		}
+	}
+	{
+		h := hangman.Reap(hangman.Sentence{Command: "seq 1 10000", Timeout: 1, MaxOutputBytes: 100, KeepTail: true})
+		if !h.StdoutTruncated {
+			t.Error("Stdout has not been truncated")
+		}
+		if len(h.Stdout) != 100 || !strings.HasPrefix(h.Stdout, "1\n2\n3\n") || !strings.HasSuffix(h.Stdout, "9999\n10000\n") {
+			t.Errorf("Unexpected truncated output on stdout: %q", h.Stdout)
+		}
+	}
+	{
+		h := hangman.Reap(hangman.Sentence{Command: "seq 1 10000", Timeout: 1, MaxOutputBytes: -1})
+		if h.StdoutTruncated || len(h.Stdout) != 48894 {
+			t.Errorf("Unlimited stdout has been truncated: %d bytes", len(h.Stdout))
+		}
+	}
+	{
+		h := hangman.Reap(hangman.Sentence{Command: "seq 1 3", Timeout: 1, MaxOutputBytes: 100})
+		if h.StdoutTruncated || h.Stdout != "1\n2\n3\n" || h.StdoutBytes != 6 {
+			t.Errorf("Unexpected output on stdout: %q", h.Stdout)
+		}
+	}
+}
+
+func TestTiming(t *testing.T) {
+	h := hangman.Reap(hangman.Sentence{Command: "i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done; sleep 0.2", Shell: "/bin/sh", Timeout: 5})
+	if h.StartTime.IsZero() || !h.EndTime.After(h.StartTime) {
+		t.Error("Start and end times are not set: ", h.StartTime, h.EndTime)
+	}
+	if h.Duration < 0.2 {
+		t.Error("Duration is less than 0.2 second: ", h.Duration)
+	}
+	if h.UserTime+h.SystemTime <= 0 {
+		t.Error("CPU time is not set: ", h.UserTime, h.SystemTime)
+	}
+	if h.MaxRSS <= 0 {
+		t.Error("Max RSS is not set: ", h.MaxRSS)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	cpu := uint64(2)
+	files := uint64(64)
+	s := hangman.Sentence{
+		Args:    []string{"sh", "-c", "ulimit -t; ulimit -n; echo $$"},
+		Timeout: 1,
+		Limits:  hangman.Limits{CPU: &cpu, OpenFiles: &files},
+	}
+	// limits are set before the program runs, never after it has read them
+	for i := 0; i < 20; i++ {
+		h := hangman.Reap(s)
+		if h.ExitCode != 0 {
+			t.Fatal("Exit code is not 0: ", h.ExitCode, h.Stderr)
+		}
+		if h.Stdout != "2\n64\n"+strconv.Itoa(h.Pid)+"\n" {
+			t.Fatalf("Unexpected limits or pid on stdout: %q", h.Stdout)
+		}
+		if h.Args[0] != "sh" {
+			t.Fatal("Executed command is not sh: ", h.ExecutedCommand)
+		}
+	}
+}
+
+func TestLookPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hangman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(dir+"/hangman-found", []byte("#!/bin/sh\necho found\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// the program is searched in the PATH of the command, not in the current one
+	h := hangman.Reap(hangman.Sentence{Args: []string{"hangman-found"}, Timeout: 1, Env: []string{"PATH=/usr/bin:/bin:" + dir}})
+	if h.Stdout != "found\n" {
+		t.Errorf("Program has not been found in the PATH of the command: %q %q", h.Stdout, h.StartError)
+	}
+	h = hangman.Reap(hangman.Sentence{Args: []string{"ls"}, Timeout: 1, Env: []string{"PATH=" + dir}})
+	if h.StartError != hangman.StartErrorNotFound {
+		t.Errorf("Program has been found outside of the PATH of the command: %q", h.StartError)
+	}
+}
+
+func TestCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Running commands as another user requires root")
+	}
+	s := hangman.Sentence{
+		Command:    "id -u",
+		Timeout:    1,
+		Credential: &hangman.Credential{UID: 65534, GID: 65534},
+	}
+	h := hangman.Reap(s)
+	if h.Stdout != "65534\n" {
+		t.Errorf("Command has not been run as uid 65534: %q %q", h.Stdout, h.Stderr)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	{
+		h := hangman.Reap(hangman.Sentence{Args: []string{"env"}, Timeout: 1, Env: []string{"GREETING=hello"}})
+		if h.Stdout != "GREETING=hello\n" {
+			t.Errorf("Unexpected environment on stdout: %q %q", h.Stdout, h.Stderr)
+		}
+	}
+	{
+		// GREETING is expanded from the environment of the command
+		s := hangman.Sentence{Command: "sh -c \"pwd; echo $GREETING\"", Timeout: 1, Dir: "/tmp", Env: []string{"GREETING=hello"}}
+		h := hangman.Reap(s)
+		if h.Stdout != "/tmp\nhello\n" {
+			t.Errorf("Unexpected output on stdout: %q %q", h.Stdout, h.Stderr)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	h := hangman.ReaperContext(ctx, "sleep 10", 5)
+	if h.Outcome != hangman.OutcomeCancelled {
+		t.Error("Outcome is not "+hangman.OutcomeCancelled+": ", h.Outcome)
+	}
+	if h.TimeoutReached {
+		t.Error("Timeout has been reached: ", h.TimeoutReached)
+	}
+	if h.Duration > 2 {
+		t.Error("Process has not been terminated on cancellation: ", h.Duration)
+	}
+}
+
+func TestParameters(t *testing.T) {
+	s := hangman.Sentence{
+		Command:    "echo {word} {other} $HOME",
+		Timeout:    1,
+		Env:        []string{"HOME=/home"},
+		Parameters: map[string]string{"word": "$HOME; ls"},
+	}
+	h := hangman.Reap(s)
+	if h.Stdout != "$HOME; ls {other} /home\n" {
+		t.Errorf("Unexpected output on stdout: %q", h.Stdout)
+	}
+	if h.OriginalCommand != "echo {word} {other} $HOME" {
+		t.Errorf("Unexpected original command: %q", h.OriginalCommand)
+	}
+}
+
+func TestStdin(t *testing.T) {
+	h := hangman.Reap(hangman.Sentence{Command: "wc -c", Timeout: 1, Stdin: strings.NewReader("It is working great")})
+	if strings.TrimSpace(h.Stdout) != "19" {
+		t.Errorf("Unexpected output on stdout: %q", h.Stdout)
+	}
+}
+
+func TestStdinStalled(t *testing.T) {
+	// nothing is ever written to the pipe, reading it blocks
+	r, w := io.Pipe()
+	defer w.Close()
+	h := hangman.Reap(hangman.Sentence{Command: "cat", Timeout: 1, Stdin: r})
+	if !h.TimeoutReached {
+		t.Errorf("Timeout is not reached: %+v", h)
+	}
+	if h.Duration > 2 {
+		t.Errorf("Execution is not ended at the timeout: %f seconds", h.Duration)
+	}
+}
+
+func TestStdinError(t *testing.T) {
+	h := hangman.Reap(hangman.Sentence{Command: "cat", Timeout: 1, Stdin: iotest.TimeoutReader(strings.NewReader("It is working great"))})
+	if h.IOError == "" || h.Outcome != hangman.OutcomeCancelled {
+		t.Errorf("Stdin error is not reported: %+v", h)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var lines []hangman.Line
+	h := hangman.ReapStream(context.Background(), hangman.Sentence{
+		Command: "echo one; sleep 0.1; echo two >&2; sleep 0.1; printf three",
+		Shell:   "/bin/sh",
+		Timeout: 1,
+	}, func(l hangman.Line) {
+		lines = append(lines, l)
+	})
+	expected := []hangman.Line{
+		{Stream: hangman.StreamStdout, Text: "one"},
+		{Stream: hangman.StreamStderr, Text: "two"},
+		{Stream: hangman.StreamStdout, Text: "three"},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Unexpected lines: %v", lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Unexpected line %d: %v instead of %v", i, lines[i], expected[i])
+		}
+	}
+	if h.Stdout != "one\nthree" || h.Stderr != "two\n" {
+		t.Errorf("Unexpected output: %q %q", h.Stdout, h.Stderr)
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	h := hangman.Reap(hangman.Sentence{
+		Command:  "sh -c 'test -t 0 && test -t 1 && stty size && read answer && echo got $answer'",
+		Timeout:  2,
+		Stdin:    strings.NewReader("yes\n"),
+		Terminal: &hangman.Terminal{Size: hangman.WindowSize{Rows: 30, Cols: 100}},
+	})
+	if h.ExitCode != 0 {
+		t.Fatalf("Unexpected exit code %d: %q", h.ExitCode, h.Stdout)
+	}
+	if !strings.Contains(h.Stdout, "30 100") || !strings.Contains(h.Stdout, "got yes") {
+		t.Errorf("Unexpected output: %q", h.Stdout)
+	}
+}
+
+func TestOutputEncoding(t *testing.T) {
+	tests := []struct {
+		command  string
+		encoding string
+		stdout   string
+		used     string
+	}{
+		{"printf abc", "", "abc", hangman.EncodingUTF8},
+		{"printf abc", hangman.EncodingBase64, "YWJj", hangman.EncodingBase64},
+		{"printf abc", hangman.EncodingAuto, "abc", hangman.EncodingUTF8},
+		{`printf '\377\376'`, hangman.EncodingAuto, "//4=", hangman.EncodingBase64},
 	}
-	if h.Timeout_reached == false {
-		t.Error("Timeout has not been reached: ", h.Timeout_reached)
+	for _, test := range tests {
+		h := hangman.Reap(hangman.Sentence{Command: test.command, Timeout: 1, OutputEncoding: test.encoding})
+		if h.Stdout != test.stdout || h.StdoutEncoding != test.used {
+			t.Errorf("Unexpected output for %s with encoding %q: %q (%s)", test.command, test.encoding, h.Stdout, h.StdoutEncoding)
+		}
+		if h.StderrEncoding != test.used && test.encoding != hangman.EncodingAuto {
+			t.Errorf("Unexpected stderr encoding for %s with encoding %q: %s", test.command, test.encoding, h.StderrEncoding)
+		}
 	}
 }
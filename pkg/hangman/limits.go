@@ -0,0 +1,83 @@
+package hangman
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// Limits are resource limits applied to a process before the program is
+// executed, on Linux only. Both the soft and the hard limits are set, so that
+// the program can not raise them. A nil value leaves the corresponding limit
+// untouched.
+type Limits struct {
+	// CPU is the CPU time in seconds (RLIMIT_CPU)
+	CPU *uint64
+	// AddressSpace is the size in bytes of the virtual memory (RLIMIT_AS)
+	AddressSpace *uint64
+	// OpenFiles is the number of open file descriptors (RLIMIT_NOFILE)
+	OpenFiles *uint64
+	// Processes is the number of processes for the user (RLIMIT_NPROC)
+	Processes *uint64
+	// FileSize is the size in bytes of the files written (RLIMIT_FSIZE)
+	FileSize *uint64
+	// CoreSize is the size in bytes of core dump files (RLIMIT_CORE)
+	CoreSize *uint64
+}
+
+type rlimit struct {
+	name     string
+	resource int
+	value    func(l Limits) *uint64
+}
+
+var rlimits = []rlimit{
+	{"cpu", unix.RLIMIT_CPU, func(l Limits) *uint64 { return l.CPU }},
+	{"as", unix.RLIMIT_AS, func(l Limits) *uint64 { return l.AddressSpace }},
+	{"nofile", unix.RLIMIT_NOFILE, func(l Limits) *uint64 { return l.OpenFiles }},
+	{"nproc", unix.RLIMIT_NPROC, func(l Limits) *uint64 { return l.Processes }},
+	{"fsize", unix.RLIMIT_FSIZE, func(l Limits) *uint64 { return l.FileSize }},
+	{"core", unix.RLIMIT_CORE, func(l Limits) *uint64 { return l.CoreSize }},
+}
+
+// Empty tells whether no limit is set
+func (l Limits) Empty() bool {
+	for _, r := range rlimits {
+		if r.value(l) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckLimits checks the limits can be applied by the current process to a
+// process run as c, nil meaning the current account. The current process can
+// not raise a hard limit without privileges, nor set the limits of a process
+// run as another user without the CAP_SYS_RESOURCE capability.
+func CheckLimits(l Limits, c *Credential) error {
+	if l.Empty() {
+		return nil
+	}
+	if c != nil && int(c.UID) != os.Geteuid() {
+		if err := checkLimitOthers(); err != nil {
+			return err
+		}
+	}
+	for _, r := range rlimits {
+		v := r.value(l)
+		if v == nil {
+			continue
+		}
+		var current unix.Rlimit
+		if err := unix.Getrlimit(r.resource, &current); err != nil {
+			return errors.New("can not get current " + r.name + " limit: " + err.Error())
+		}
+		if *v > current.Max && os.Geteuid() != 0 {
+			return errors.New(r.name + " limit " + strconv.FormatUint(*v, 10) +
+				" is above the current hard limit " + strconv.FormatUint(current.Max, 10))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package hangman
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// startLimited starts cmd with the limits l. The process is traced so that it
+// stops as soon as the program is executed, before running any of its code:
+// the limits are then set with prlimit(2) and the process is released. If the
+// limits can not be set, the process is killed.
+func startLimited(cmd *exec.Cmd, l Limits) error {
+	// the tracer of the process is the thread which started it
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd.SysProcAttr.Ptrace = true
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	abort := func(err error) error {
+		syscall.Kill(pid, syscall.SIGKILL)
+		syscall.PtraceDetach(pid)
+		cmd.Wait()
+		return err
+	}
+
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, syscall.WALL, nil); err != nil {
+		return abort(errors.New("can not wait for the process to stop: " + err.Error()))
+	}
+	if !ws.Stopped() {
+		return abort(errors.New("process did not stop before executing the program"))
+	}
+	for _, r := range rlimits {
+		v := r.value(l)
+		if v == nil {
+			continue
+		}
+		if err := unix.Prlimit(pid, r.resource, &unix.Rlimit{Cur: *v, Max: *v}, nil); err != nil {
+			return abort(errors.New("can not set " + r.name + " limit to " + strconv.FormatUint(*v, 10) + ": " + err.Error()))
+		}
+	}
+	if err := syscall.PtraceDetach(pid); err != nil {
+		return abort(errors.New("can not release the process: " + err.Error()))
+	}
+	return nil
+}
+
+// checkLimitOthers checks the current process has the CAP_SYS_RESOURCE
+// capability, required to set the limits of a process run as another user
+func checkLimitOthers() error {
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&header, &data[0]); err != nil {
+		return errors.New("can not get capabilities: " + err.Error())
+	}
+	if data[unix.CAP_SYS_RESOURCE/32].Effective&(1<<(unix.CAP_SYS_RESOURCE%32)) == 0 {
+		return errors.New("setting the limits of a command run as another user requires the CAP_SYS_RESOURCE capability")
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package hangman
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// checkLimitOthers fails, resource limits being only supported on Linux
+func checkLimitOthers() error {
+	return errors.New("resource limits are only supported on Linux")
+}
+
+// startLimited fails, resource limits being applied with prlimit(2) which only exists on Linux
+func startLimited(cmd *exec.Cmd, l Limits) error {
+	return errors.New("resource limits are only supported on Linux")
+}
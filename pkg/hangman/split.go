@@ -0,0 +1,149 @@
+package hangman
+
+import (
+	"errors"
+	"strings"
+)
+
+// Split breaks a command line into a list of arguments following the POSIX
+// shell quoting rules: words are separated by blanks, single quotes preserve
+// everything literally, double quotes preserve everything but backslash
+// escapes and variable references, and a backslash outside quotes escapes the
+// next character.
+//
+// When mapping is not nil, $VAR and ${VAR} references outside single quotes
+// are replaced by mapping(VAR). The result of an expansion is never split
+// into several arguments.
+func Split(cmdline string, mapping func(string) string) ([]string, error) {
+	args := make([]string, 0)
+	var word strings.Builder
+	inWord := false
+
+	runes := []rune(cmdline)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case r == '\\':
+			if i+1 >= len(runes) {
+				return nil, errors.New("trailing backslash in command line \"" + cmdline + "\"")
+			}
+			i++
+			// backslash-newline is a line continuation
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+
+		case r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != '\'' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, errors.New("unbalanced single quote in command line \"" + cmdline + "\"")
+			}
+			word.WriteString(string(runes[i+1 : end]))
+			inWord = true
+			i = end
+
+		case r == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				switch {
+				case runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]):
+					i++
+					if runes[i] != '\n' {
+						word.WriteRune(runes[i])
+					}
+				case runes[i] == '$' && mapping != nil:
+					n, err := expand(runes[i:], mapping, &word)
+					if err != nil {
+						return nil, errors.New(err.Error() + " in command line \"" + cmdline + "\"")
+					}
+					i += n - 1
+				default:
+					word.WriteRune(runes[i])
+				}
+			}
+			if i >= len(runes) {
+				return nil, errors.New("unbalanced double quote in command line \"" + cmdline + "\"")
+			}
+			inWord = true
+
+		case r == '$' && mapping != nil:
+			n, err := expand(runes[i:], mapping, &word)
+			if err != nil {
+				return nil, errors.New(err.Error() + " in command line \"" + cmdline + "\"")
+			}
+			i += n - 1
+			inWord = true
+
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// expand writes the expansion of the variable reference starting at s[0]
+// (which is a '$') to w and returns the number of runes consumed
+func expand(s []rune, mapping func(string) string, w *strings.Builder) (int, error) {
+	if len(s) > 1 && s[1] == '{' {
+		end := 2
+		for end < len(s) && s[end] != '}' {
+			end++
+		}
+		if end >= len(s) {
+			return 0, errors.New("unbalanced brace in variable reference")
+		}
+		if end == 2 {
+			return 0, errors.New("empty variable reference")
+		}
+		w.WriteString(mapping(string(s[2:end])))
+		return end + 1, nil
+	}
+
+	end := 1
+	for end < len(s) && isNameRune(s[end], end == 1) {
+		end++
+	}
+	if end == 1 {
+		// a lone '$' is kept as is
+		w.WriteRune('$')
+		return 1, nil
+	}
+	w.WriteString(mapping(string(s[1:end])))
+	return end, nil
+}
+
+func isNameRune(r rune, first bool) bool {
+	if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+		return true
+	}
+	return !first && r >= '0' && r <= '9'
+}
+
+// join is the reverse of Split: it builds a command line from a list of
+// arguments, quoting them when needed
+func join(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`|&;<>()*?[]#~{}!") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,146 @@
+package hangman
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Kinds of errors preventing a command from being started, as reported in Harvest.StartError
+const (
+	// StartErrorInvalid means the command line can not be parsed
+	StartErrorInvalid string = "invalid_command"
+	// StartErrorNotFound means the program to execute does not exist
+	StartErrorNotFound string = "not_found"
+	// StartErrorPermission means the program to execute can not be accessed or executed
+	StartErrorPermission string = "permission_denied"
+	// StartErrorOther is any other error preventing the program from being started
+	StartErrorOther string = "other"
+)
+
+// Outcomes of an execution, as reported in Harvest.Outcome
+const (
+	// OutcomeSuccess means the process exited with code 0
+	OutcomeSuccess string = "success"
+	// OutcomeFailure means the process exited with another code, or was terminated by a signal
+	// not sent by the Reaper
+	OutcomeFailure string = "failure"
+	// OutcomeTimeout means the process was terminated as the timeout was reached
+	OutcomeTimeout string = "timeout"
+	// OutcomeCancelled means the process was terminated as the execution was cancelled
+	OutcomeCancelled string = "cancelled"
+	// OutcomeStartFailure means the process could not be started
+	OutcomeStartFailure string = "start_failure"
+)
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGUSR1: "SIGUSR1",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGUSR2: "SIGUSR2",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGXCPU: "SIGXCPU",
+	syscall.SIGXFSZ: "SIGXFSZ",
+	syscall.SIGSYS:  "SIGSYS",
+}
+
+// signalName returns the conventional name of a signal, such as SIGKILL
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return "SIG" + strconv.Itoa(int(sig))
+}
+
+// startErrorKind classifies an error returned by exec.Cmd.Start
+func startErrorKind(err error) string {
+	if e, ok := err.(*exec.Error); ok {
+		err = e.Err
+	}
+	switch {
+	case err == exec.ErrNotFound || os.IsNotExist(err):
+		return StartErrorNotFound
+	case os.IsPermission(err):
+		return StartErrorPermission
+	default:
+		return StartErrorOther
+	}
+}
+
+// setStartError records in h that the command could not be started
+func (h *Harvest) setStartError(kind string, err error) {
+	h.Pid = -1
+	h.ReturnCode = -1
+	h.ExitCode = -1
+	h.TimeoutReached = false
+	h.StartError = kind
+	h.Outcome = OutcomeStartFailure
+	h.Stderr = err.Error()
+	h.StdoutEncoding = EncodingUTF8
+	h.StderrEncoding = EncodingUTF8
+}
+
+// setCancelled records in h that the execution was cancelled before the process was started
+func (h *Harvest) setCancelled() {
+	h.Pid = -1
+	h.ReturnCode = -1
+	h.ExitCode = -1
+	h.Outcome = OutcomeCancelled
+	h.StdoutEncoding = EncodingUTF8
+	h.StderrEncoding = EncodingUTF8
+}
+
+// setEndTime records in h when the execution ended and how long it lasted
+func (h *Harvest) setEndTime(end time.Time) {
+	h.EndTime = end
+	h.Duration = end.Sub(h.StartTime).Seconds()
+}
+
+// setStatus records in h how the process ended, according to its wait status,
+// and the resources it used
+func (h *Harvest) setStatus(state *os.ProcessState) {
+	h.ReturnCode = -1
+	h.ExitCode = -1
+	h.Outcome = OutcomeFailure
+	if state == nil {
+		return
+	}
+	h.UserTime = state.UserTime().Seconds()
+	h.SystemTime = state.SystemTime().Seconds()
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		// ru_maxrss is given in kilobytes
+		h.MaxRSS = int64(usage.Maxrss)
+	}
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		h.ExitCode = state.ExitCode()
+		h.ReturnCode = h.ExitCode
+		if h.ExitCode == 0 {
+			h.Outcome = OutcomeSuccess
+		}
+		return
+	}
+	switch {
+	case ws.Exited():
+		h.ExitCode = ws.ExitStatus()
+		h.ReturnCode = h.ExitCode
+		if h.ExitCode == 0 {
+			h.Outcome = OutcomeSuccess
+		}
+	case ws.Signaled():
+		h.Signal = signalName(ws.Signal())
+		h.CoreDumped = ws.CoreDump()
+	}
+}
@@ -0,0 +1,67 @@
+package hangman
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// stdinPipe feeds the standard input of a process through a pipe. Unlike the
+// copy done by exec.Cmd, which cmd.Wait waits for, the copy is left behind once
+// the process has exited or been killed, so that a stalled reader, such as the
+// body of a slow HTTP request, does not keep the execution from ending.
+type stdinPipe struct {
+	r      *os.File
+	w      *os.File
+	failed chan error
+}
+
+// newStdinPipe opens a pipe and sets cmd to read it as its standard input
+func newStdinPipe(cmd *exec.Cmd) (*stdinPipe, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = r
+	return &stdinPipe{r: r, w: w, failed: make(chan error, 1)}, nil
+}
+
+// start copies stdin to the pipe once the process is started. The pipe is
+// closed at the end of stdin, the process then reading an end of file. If stdin
+// can not be read, the error is sent on failed and the pipe is left open.
+func (p *stdinPipe) start(stdin io.Reader) {
+	// the process has its own copy of the read end
+	p.r.Close()
+	go func() {
+		_, err := io.Copy(p.w, stdin)
+		// the process exited without reading everything, or is gone
+		if errors.Is(err, syscall.EPIPE) || errors.Is(err, os.ErrClosed) {
+			err = nil
+		}
+		// on error, the pipe is left open so that the process does not take
+		// what it has read for its whole standard input: it is terminated
+		if err != nil {
+			p.failed <- err
+			return
+		}
+		p.w.Close()
+	}()
+}
+
+// errors returns the channel the error which ended the copy is sent on, nil
+// if there is no pipe
+func (p *stdinPipe) errors() <-chan error {
+	if p == nil {
+		return nil
+	}
+	return p.failed
+}
+
+// close closes the pipe, ending the copy if it is still writing. A copy still
+// waiting for stdin ends once stdin is read.
+func (p *stdinPipe) close() {
+	p.r.Close()
+	p.w.Close()
+}
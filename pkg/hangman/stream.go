@@ -0,0 +1,62 @@
+package hangman
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Output streams, as reported in Line.Stream
+const (
+	StreamStdout string = "stdout"
+	StreamStderr string = "stderr"
+)
+
+// maxLineBytes is the size above which a line without end is given in several parts
+const maxLineBytes = 64 * 1024
+
+// Line is a line written by a process on one of its outputs, without its end of line
+type Line struct {
+	Stream string
+	Text   string
+}
+
+// lineWriter is an io.Writer calling out for each line written to it. Lines
+// written to lineWriters sharing the same mutex are given one at a time.
+type lineWriter struct {
+	stream string
+	out    func(Line)
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.buf = append(lw.buf, p...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 && len(lw.buf) < maxLineBytes {
+			return len(p), nil
+		}
+		if i < 0 || i > maxLineBytes {
+			i = maxLineBytes
+			lw.emit(lw.buf[:i])
+			lw.buf = lw.buf[i:]
+			continue
+		}
+		lw.emit(bytes.TrimSuffix(lw.buf[:i], []byte("\r")))
+		lw.buf = lw.buf[i+1:]
+	}
+}
+
+// flush gives the last line if it has no end of line
+func (lw *lineWriter) flush() {
+	if len(lw.buf) > 0 {
+		lw.emit(lw.buf)
+		lw.buf = nil
+	}
+}
+
+func (lw *lineWriter) emit(b []byte) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.out(Line{lw.stream, string(b)})
+}
@@ -0,0 +1,111 @@
+package hangman
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// WindowSize is the size of a terminal, in characters
+type WindowSize struct {
+	Rows uint16
+	Cols uint16
+}
+
+// Terminal describes the pseudo-terminal a process is run with
+type Terminal struct {
+	// Size is the initial size of the terminal, 24x80 if zero
+	Size WindowSize
+	// Resize gives the new sizes of the terminal while the process is running, if not nil
+	Resize <-chan WindowSize
+}
+
+// terminal is a pseudo-terminal attached to a command
+type terminal struct {
+	ptmx   *os.File
+	tty    *os.File
+	output chan struct{}
+}
+
+// newTerminal opens a pseudo-terminal and sets cmd to use it as its controlling
+// terminal, standard input and outputs, in a new session
+func newTerminal(cmd *exec.Cmd, t *Terminal) (*terminal, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	size := t.Size
+	if size.Rows == 0 || size.Cols == 0 {
+		size = WindowSize{24, 80}
+	}
+	if err := pty.Setsize(ptmx, &pty.Winsize{Rows: size.Rows, Cols: size.Cols}); err != nil {
+		ptmx.Close()
+		tty.Close()
+		return nil, err
+	}
+
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	// a new session is also a new process group, led by the process
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+	return &terminal{ptmx: ptmx, tty: tty, output: make(chan struct{})}, nil
+}
+
+// start copies stdin to the terminal and the terminal to stdout once the command
+// is started. At the end of stdin, an end of transmission (^D) is sent.
+func (t *terminal) start(stdin io.Reader, stdout io.Writer, resize <-chan WindowSize) {
+	t.tty.Close()
+	go func() {
+		// reading fails with EIO once the process and its children are gone
+		io.Copy(stdout, t.ptmx)
+		close(t.output)
+	}()
+	if stdin != nil {
+		go func() {
+			if _, err := io.Copy(t.ptmx, stdin); err == nil {
+				t.ptmx.Write([]byte{4})
+			}
+		}()
+	}
+	if resize != nil {
+		go func() {
+			for {
+				select {
+				case size, ok := <-resize:
+					if !ok {
+						return
+					}
+					pty.Setsize(t.ptmx, &pty.Winsize{Rows: size.Rows, Cols: size.Cols})
+				case <-t.output:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// wait waits for the output of the terminal to be copied, then closes it. The
+// output is read for at most waitDelay, descendants of the process which left
+// its session possibly keeping the terminal open.
+func (t *terminal) wait() {
+	select {
+	case <-t.output:
+	case <-time.After(waitDelay):
+	}
+	// closing the terminal ends the copy if it is still running
+	t.ptmx.Close()
+	<-t.output
+}
+
+// close releases the terminal of a command which could not be started
+func (t *terminal) close() {
+	t.ptmx.Close()
+	t.tty.Close()
+}
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"container/heap"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/config"
+	"github.com/etombini/http-cmd/pkg/signing"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswdCheckInterval is the minimum delay between two checks of the htpasswd file
+const htpasswdCheckInterval = time.Second * 10
+
+var (
+	// errUnauthenticated means the request carries no credentials while some are required
+	errUnauthenticated = errors.New("authentication required")
+	// errInvalidCredentials means the request carries credentials which are not valid
+	errInvalidCredentials = errors.New("invalid credentials")
+	// errStaleSignature means the timestamp of a signed request is out of the accepted skew
+	errStaleSignature = errors.New("stale signature timestamp")
+	// errReplayedSignature means the nonce of a signed request was already used
+	errReplayedSignature = errors.New("replayed signature nonce")
+)
+
+// principal is an authenticated client and what it is granted, a nil Grant
+// allowing everything
+type principal struct {
+	name  string
+	grant *config.Grant
+}
+
+// authenticator authenticates the clients from a kind of credentials
+type authenticator interface {
+	// authenticate returns the principal authenticated by the credentials of r,
+	// nil if r carries no credentials of this kind, or errInvalidCredentials
+	authenticate(r *http.Request) (*principal, error)
+	// challenge returns the WWW-Authenticate header value asking for credentials
+	// of this kind, or an empty string
+	challenge() string
+}
+
+// auth authenticates the clients with the first authenticator they give
+// credentials for. Without authenticator, clients are allowed everything.
+type auth struct {
+	authenticators []authenticator
+}
+
+// newAuth returns an auth with the authenticators configured
+func newAuth(config config.Config) *auth {
+	a := &auth{}
+	if config.Server.TLS.ClientAuth() {
+		a.authenticators = append(a.authenticators, tlsAuthenticator{config.Server.TLS})
+	}
+	if len(config.Server.APIKeys) > 0 {
+		a.authenticators = append(a.authenticators, newAPIKeyAuthenticator(config.Server.APIKeys))
+	}
+	if config.Server.Htpasswd.Enabled() {
+		a.authenticators = append(a.authenticators, newHtpasswdAuthenticator(config.Server.Htpasswd))
+	}
+	if len(config.Server.HMACKeys) > 0 {
+		a.authenticators = append(a.authenticators, newHMACAuthenticator(config))
+	}
+	return a
+}
+
+// grant returns what the client of r is granted, nil meaning everything
+func (a *auth) grant(r *http.Request) (*config.Grant, error) {
+	if len(a.authenticators) == 0 {
+		return nil, nil
+	}
+	for _, authenticator := range a.authenticators {
+		p, err := authenticator.authenticate(r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Authentication failed for %s %s from %s: %s\n", r.Method, r.URL.Path, r.RemoteAddr, err.Error())
+			return nil, err
+		}
+		if p != nil {
+			return p.grant, nil
+		}
+	}
+	return nil, errUnauthenticated
+}
+
+// allowed tells whether the client of r may run exec of category. If not,
+// the request is answered with a 401 or a 403 status.
+func (a *auth) allowed(w http.ResponseWriter, r *http.Request, category string, exec string) bool {
+	grant, err := a.grant(r)
+	if err != nil {
+		a.unauthorized(w, err)
+		return false
+	}
+	if !grant.Allows(category, exec) {
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// unauthorized answers a request with a 401 status, asking for credentials,
+// or with the status carried by err
+func (a *auth) unauthorized(w http.ResponseWriter, err error) {
+	if _, ok := err.(requestError); ok {
+		httpError(w, err)
+		return
+	}
+	for _, authenticator := range a.authenticators {
+		if c := authenticator.challenge(); c != "" {
+			w.Header().Add("WWW-Authenticate", c)
+		}
+	}
+	http.Error(w, "401 unauthorized: "+err.Error(), http.StatusUnauthorized)
+}
+
+// tlsAuthenticator authenticates clients from the certificate verified during
+// the TLS handshake. A client not matching any configured one is granted nothing.
+// Clients without a certificate, allowed when other authenticators are
+// configured, are left to them.
+type tlsAuthenticator struct {
+	tls config.TLS
+}
+
+func (t tlsAuthenticator) authenticate(r *http.Request) (*principal, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	cert := r.TLS.PeerCertificates[0]
+	grant, ok := t.tls.ClientGrant(cert)
+	if !ok {
+		grant = &config.Grant{}
+	}
+	return &principal{cert.Subject.String(), grant}, nil
+}
+
+func (t tlsAuthenticator) challenge() string {
+	return ""
+}
+
+// apiKeyAuthenticator authenticates clients from an API key given in an
+// "Authorization: Bearer" or "X-API-Key" header
+type apiKeyAuthenticator struct {
+	keys map[string]config.APIKey
+}
+
+func newAPIKeyAuthenticator(keys []config.APIKey) apiKeyAuthenticator {
+	a := apiKeyAuthenticator{make(map[string]config.APIKey)}
+	for _, key := range keys {
+		a.keys[key.Hash] = key
+	}
+	return a
+}
+
+func (a apiKeyAuthenticator) authenticate(r *http.Request) (*principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if authorization := r.Header.Get("Authorization"); key == "" && len(authorization) > 7 &&
+		strings.EqualFold(authorization[:7], "Bearer ") {
+		key = strings.TrimSpace(authorization[7:])
+	}
+	if key == "" {
+		return nil, nil
+	}
+	// keys are looked up by hash, which does not leak them through timing
+	k, ok := a.keys[config.HashAPIKey(key)]
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+	return &principal{k.Name, &k.Grant}, nil
+}
+
+func (a apiKeyAuthenticator) challenge() string {
+	return `Bearer realm="http-cmd"`
+}
+
+// htpasswdAuthenticator authenticates clients from a user and a password given
+// with HTTP Basic authentication, reloading the htpasswd file when it is
+// modified. If the modified file can not be loaded, the previous users are kept.
+// The password of an unknown user is compared to a dummy hash of the highest
+// cost of the file, so that users can not be told apart by the response time.
+type htpasswdAuthenticator struct {
+	htpasswd config.Htpasswd
+
+	mu      sync.Mutex
+	users   map[string][]byte
+	dummy   []byte
+	modTime time.Time
+	checked time.Time
+}
+
+func newHtpasswdAuthenticator(htpasswd config.Htpasswd) *htpasswdAuthenticator {
+	h := &htpasswdAuthenticator{htpasswd: htpasswd}
+	if err := h.reload(); err != nil {
+		fmt.Fprintf(os.Stderr, "Can not load htpasswd file %s: %s\n", htpasswd.File, err.Error())
+	}
+	return h
+}
+
+// reload loads the htpasswd file. It must be called with mu held.
+func (h *htpasswdAuthenticator) reload() error {
+	fi, err := os.Stat(h.htpasswd.File)
+	if err != nil {
+		return err
+	}
+	users, err := config.LoadHtpasswd(h.htpasswd.File)
+	if err != nil {
+		return err
+	}
+	cost := bcrypt.MinCost
+	for _, hash := range users {
+		if c, _ := bcrypt.Cost(hash); c > cost {
+			cost = c
+		}
+	}
+	if c, err := bcrypt.Cost(h.dummy); err != nil || c != cost {
+		dummy, err := bcrypt.GenerateFromPassword([]byte("http-cmd dummy password"), cost)
+		if err != nil {
+			return err
+		}
+		h.dummy = dummy
+	}
+	if missing := h.htpasswd.MissingUsers(users); len(missing) != 0 {
+		fmt.Fprintf(os.Stderr, "Htpasswd group users not found in %s: %s\n", h.htpasswd.File, strings.Join(missing, ", "))
+	}
+	h.users = users
+	h.modTime = fi.ModTime()
+	return nil
+}
+
+// hash returns the hashed password of user, or the dummy hash if user is
+// unknown, reloading the htpasswd file first if it was modified. A file whose
+// modification time changed, even to an earlier one, is considered modified.
+func (h *htpasswdAuthenticator) hash(user string) ([]byte, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.checked) >= htpasswdCheckInterval {
+		h.checked = time.Now()
+		fi, err := os.Stat(h.htpasswd.File)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can not check htpasswd file, keeping the current users: %s\n", err.Error())
+		} else if !fi.ModTime().Equal(h.modTime) {
+			if err := h.reload(); err != nil {
+				fmt.Fprintf(os.Stderr, "Can not reload htpasswd file, keeping the current users: %s\n", err.Error())
+			} else {
+				fmt.Fprintf(os.Stderr, "Htpasswd file reloaded from %s\n", h.htpasswd.File)
+			}
+		}
+	}
+	hash, ok := h.users[user]
+	if !ok {
+		return h.dummy, false
+	}
+	return hash, true
+}
+
+func (h *htpasswdAuthenticator) authenticate(r *http.Request) (*principal, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	// the password is compared even if the user is unknown
+	hash, ok := h.hash(user)
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !ok {
+		return nil, errInvalidCredentials
+	}
+	return &principal{user, h.htpasswd.UserGrant(user)}, nil
+}
+
+func (h *htpasswdAuthenticator) challenge() string {
+	return `Basic realm="http-cmd", charset="UTF-8"`
+}
+
+// hmacAuthenticator authenticates clients from the signature of their requests,
+// made with a secret shared with the server as done by client.Sign. The
+// signature covers the headers of the request, including the hash of its body:
+// it is checked before the body is read, the body being checked against its
+// hash once read to its end. A body given to a command is read in full first,
+// so that the command never runs on a body not matching its hash. Signatures whose timestamp differs from the server
+// time by more than maxSkew are rejected, and nonces are remembered while their
+// timestamp is accepted so that a signed request can not be replayed.
+type hmacAuthenticator struct {
+	keys    map[string]config.HMACKey
+	maxSkew time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]bool
+	expiry nonceQueue
+}
+
+func newHMACAuthenticator(c config.Config) *hmacAuthenticator {
+	h := &hmacAuthenticator{
+		keys:    make(map[string]config.HMACKey),
+		maxSkew: time.Duration(c.Server.HMACMaxSkew) * time.Second,
+		nonces:  make(map[string]bool),
+	}
+	for _, key := range c.Server.HMACKeys {
+		h.keys[key.Name] = key
+	}
+	return h
+}
+
+// usedNonce is a nonce remembered until its expiry
+type usedNonce struct {
+	nonce  string
+	expiry time.Time
+}
+
+// nonceQueue is a heap of nonces ordered by expiry, as expiries do not follow
+// the order the requests are received in
+type nonceQueue []usedNonce
+
+func (q nonceQueue) Len() int            { return len(q) }
+func (q nonceQueue) Less(i, j int) bool  { return q[i].expiry.Before(q[j].expiry) }
+func (q nonceQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nonceQueue) Push(x interface{}) { *q = append(*q, x.(usedNonce)) }
+func (q *nonceQueue) Pop() interface{} {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
+
+// use records nonce of key as used until expiry, and tells whether it was not
+// already. Expired nonces are forgotten first.
+func (h *hmacAuthenticator) use(key string, nonce string, expiry time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for h.expiry.Len() > 0 && now.After(h.expiry[0].expiry) {
+		delete(h.nonces, heap.Pop(&h.expiry).(usedNonce).nonce)
+	}
+	n := key + "/" + nonce
+	if h.nonces[n] {
+		return false
+	}
+	h.nonces[n] = true
+	heap.Push(&h.expiry, usedNonce{n, expiry})
+	return true
+}
+
+func (h *hmacAuthenticator) authenticate(r *http.Request) (*principal, error) {
+	a, ok, err := signing.ParseAuthorization(r.Header.Get("Authorization"))
+	if !ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	key, ok := h.keys[a.Key]
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+	signedAt := time.Unix(a.Timestamp, 0)
+	if skew := time.Since(signedAt); skew > h.maxSkew || skew < -h.maxSkew {
+		return nil, errStaleSignature
+	}
+	contentHash := r.Header.Get(signing.ContentHashHeader)
+	if !signing.ValidContentHash(contentHash) {
+		return nil, badRequest("signed request requires a valid " + signing.ContentHashHeader + " header")
+	}
+	signature := signing.Signature([]byte(key.Secret), signing.StringToSign(r, a.Timestamp, a.Nonce))
+	if !hmac.Equal([]byte(signature), []byte(a.Signature)) {
+		return nil, errInvalidCredentials
+	}
+	if !h.use(key.Name, a.Nonce, signedAt.Add(h.maxSkew)) {
+		return nil, errReplayedSignature
+	}
+	if r.Body != nil {
+		r.Body = signing.VerifyBody(r.Body, contentHash)
+	}
+	return &principal{key.Name, &key.Grant}, nil
+}
+
+func (h *hmacAuthenticator) challenge() string {
+	return signing.Scheme + ` realm="http-cmd"`
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	httpclient "github.com/etombini/http-cmd/pkg/client"
+	"github.com/etombini/http-cmd/pkg/hangman"
+	"github.com/etombini/http-cmd/pkg/signing"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// grantTest is a request expected to be answered with status
+type grantTest struct {
+	url    string
+	status int
+}
+
+// catalogNames returns the names listed by a catalog response
+func catalogNames(t *testing.T, body string) []string {
+	var entries []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatalf("Response is not a catalog: %s: %q", err.Error(), body)
+	}
+	names := make([]string, 0)
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+// checkGrants sends the requests of tests with client, setting credentials
+// with authenticate, and checks their status
+func checkGrants(t *testing.T, client *http.Client, ts string, name string, authenticate func(*http.Request), tests []grantTest) {
+	for _, test := range tests {
+		r := request(t, http.MethodGet, ts+test.url, "", "")
+		if authenticate != nil {
+			authenticate(r)
+		}
+		resp, body := doClient(t, client, r)
+		if resp.StatusCode != test.status {
+			t.Errorf("Unexpected status for %s on %s: %d instead of %d: %q", name, test.url, resp.StatusCode, test.status, body)
+		}
+	}
+}
+
+func TestAuthTLS(t *testing.T) {
+	cfg := testConfig(t, "auth")
+	cfg.Server.APIKeys = nil
+	cfg.Server.Htpasswd.File = ""
+	cfg.Server.HMACKeys = nil
+	ts := testTLSServer(t, cfg)
+
+	checkGrants(t, testTLSClient(t, "alice"), ts.URL, "alice", nil, []grantTest{
+		{"/run/system/uptime", http.StatusOK},
+		{"/run/network/hostname", http.StatusForbidden},
+		{"/stream/network/hostname", http.StatusForbidden},
+		{"/catalog/network", http.StatusNotFound},
+	})
+	checkGrants(t, testTLSClient(t, "monitor"), ts.URL, "monitor", nil, []grantTest{
+		{"/run/network/hostname", http.StatusOK},
+		{"/run/network/interfaces", http.StatusForbidden},
+		{"/run/system/uptime", http.StatusForbidden},
+		{"/catalog/system", http.StatusNotFound},
+	})
+
+	// categories and execs not granted are hidden from the catalog
+	tests := []struct {
+		cert     string
+		url      string
+		expected string
+	}{
+		{"alice", "/catalog/", `["system"]`},
+		{"alice", "/catalog/system", `["save","uptime"]`},
+		{"monitor", "/catalog/", `["network"]`},
+		{"monitor", "/catalog/network", `["hostname"]`},
+	}
+	for _, test := range tests {
+		resp, body := doClient(t, testTLSClient(t, test.cert), request(t, http.MethodGet, ts.URL+test.url, "", ""))
+		names, _ := json.Marshal(catalogNames(t, body))
+		if resp.StatusCode != http.StatusOK || string(names) != test.expected {
+			t.Errorf("Unexpected catalog %s for %s: %d %s instead of %s", test.url, test.cert, resp.StatusCode, names, test.expected)
+		}
+	}
+
+	// a certificate is required when clients can not authenticate otherwise
+	if _, err := testTLSClient(t, "").Do(request(t, http.MethodGet, ts.URL+"/catalog/", "", "")); err == nil {
+		t.Error("Client without certificate is accepted")
+	}
+}
+
+func TestAuthAPIKey(t *testing.T) {
+	ts := testTLSServer(t, testConfig(t, "auth"))
+	client := testTLSClient(t, "")
+
+	// clients without certificate can authenticate otherwise
+	resp, body := doClient(t, client, request(t, http.MethodGet, ts.URL+"/run/system/uptime", "", ""))
+	challenges := resp.Header.Values("WWW-Authenticate")
+	expected := []string{`Bearer realm="http-cmd"`, `Basic realm="http-cmd", charset="UTF-8"`, `HMAC-SHA256 realm="http-cmd"`}
+	if resp.StatusCode != http.StatusUnauthorized || strings.Join(challenges, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected response without credentials: %d, WWW-Authenticate %q: %q", resp.StatusCode, challenges, body)
+	}
+
+	bearer := func(key string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+key) }
+	}
+	checkGrants(t, client, ts.URL, "ci", bearer("ci-key"), []grantTest{
+		{"/run/system/uptime", http.StatusOK},
+		{"/run/network/hostname", http.StatusForbidden},
+		{"/catalog/", http.StatusOK},
+	})
+	// keys of the keys file, given in the X-API-Key header
+	checkGrants(t, client, ts.URL, "monitor", func(r *http.Request) { r.Header.Set("X-API-Key", "monitor-key") }, []grantTest{
+		{"/run/network/hostname", http.StatusOK},
+		{"/run/system/uptime", http.StatusForbidden},
+	})
+	checkGrants(t, client, ts.URL, "an invalid key", bearer("wrong-key"), []grantTest{
+		{"/run/system/uptime", http.StatusUnauthorized},
+		{"/catalog/", http.StatusUnauthorized},
+	})
+	// certificates are still used
+	checkGrants(t, testTLSClient(t, "alice"), ts.URL, "alice", nil, []grantTest{
+		{"/run/system/uptime", http.StatusOK},
+	})
+
+	r := request(t, http.MethodGet, ts.URL+"/catalog/", "", "")
+	bearer("monitor-key")(r)
+	if resp, body := doClient(t, client, r); strings.Join(catalogNames(t, body), ",") != "network" {
+		t.Errorf("Unexpected catalog for monitor: %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestAuthHtpasswd(t *testing.T) {
+	ts := testTLSServer(t, testConfig(t, "auth"))
+	client := testTLSClient(t, "")
+
+	basic := func(user string, password string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, password) }
+	}
+	checkGrants(t, client, ts.URL, "alice", basic("alice", "alice-password"), []grantTest{
+		{"/run/system/uptime", http.StatusOK},
+		{"/run/network/hostname", http.StatusOK},
+		{"/run/network/interfaces", http.StatusForbidden},
+	})
+	checkGrants(t, client, ts.URL, "bob", basic("bob", "bob-password"), []grantTest{
+		{"/run/network/hostname", http.StatusOK},
+		{"/run/system/uptime", http.StatusForbidden},
+	})
+	checkGrants(t, client, ts.URL, "alice with a wrong password", basic("alice", "bob-password"), []grantTest{
+		{"/run/system/uptime", http.StatusUnauthorized},
+	})
+	checkGrants(t, client, ts.URL, "an unknown user", basic("carol", "carol-password"), []grantTest{
+		{"/run/system/uptime", http.StatusUnauthorized},
+	})
+}
+
+func TestHtpasswdReload(t *testing.T) {
+	cfg := testConfig(t, "auth")
+	b, err := ioutil.ReadFile(cfg.Server.Htpasswd.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "users.htpasswd")
+	if err := ioutil.WriteFile(file, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	htpasswd := cfg.Server.Htpasswd
+	htpasswd.File = file
+	h := newHtpasswdAuthenticator(htpasswd)
+
+	authenticate := func(user string, password string) error {
+		r := request(t, http.MethodGet, "/run/network/hostname", "", "")
+		r.SetBasicAuth(user, password)
+		_, err := h.authenticate(r)
+		return err
+	}
+	if err := authenticate("bob", "bob-password"); err != nil {
+		t.Fatalf("Can not authenticate bob: %s", err.Error())
+	}
+
+	// the modification time goes back, the file is modified all the same
+	hash, err := bcrypt.GenerateFromPassword([]byte("new-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(b), "\n")
+	for i := range lines {
+		if strings.HasPrefix(lines[i], "bob:") {
+			lines[i] = "bob:" + string(hash)
+		}
+	}
+	if err := ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is checked at most every htpasswdCheckInterval
+	if err := authenticate("bob", "bob-password"); err != nil {
+		t.Errorf("Htpasswd file is checked again before htpasswdCheckInterval: %v", err)
+	}
+	h.checked = time.Time{}
+	if err := authenticate("bob", "new-password"); err != nil {
+		t.Errorf("Can not authenticate bob with the new password: %v", err)
+	}
+	if err := authenticate("bob", "bob-password"); err != errInvalidCredentials {
+		t.Errorf("Old password of bob is checked with %v, expected %v", err, errInvalidCredentials)
+	}
+	if err := authenticate("alice", "alice-password"); err != nil {
+		t.Errorf("Can not authenticate alice after reload: %v", err)
+	}
+
+	// the users are kept if the file can not be loaded
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	h.checked = time.Time{}
+	if err := authenticate("bob", "new-password"); err != nil {
+		t.Errorf("Users are not kept once the file is removed: %v", err)
+	}
+}
+
+func TestAuthHMAC(t *testing.T) {
+	ts := testTLSServer(t, testConfig(t, "auth"))
+	client := testTLSClient(t, "")
+	deploy := []byte("0123456789abcdef0123456789abcdef")
+	monitor := []byte("fedcba9876543210fedcba9876543210")
+
+	sign := func(key string, secret []byte) func(*http.Request) {
+		return func(r *http.Request) {
+			if err := httpclient.Sign(r, key, secret); err != nil {
+				t.Fatal("Can not sign request: " + err.Error())
+			}
+		}
+	}
+	checkGrants(t, client, ts.URL, "deploy", sign("deploy", deploy), []grantTest{
+		{"/run/system/uptime", http.StatusOK},
+		{"/run/network/hostname", http.StatusForbidden},
+	})
+	checkGrants(t, client, ts.URL, "monitor", sign("monitor", monitor), []grantTest{
+		{"/run/network/hostname", http.StatusOK},
+		{"/run/system/uptime", http.StatusForbidden},
+	})
+	checkGrants(t, client, ts.URL, "deploy with a wrong secret", sign("deploy", monitor), []grantTest{
+		{"/run/system/uptime", http.StatusUnauthorized},
+	})
+
+	// a signed request is used once
+	r := request(t, http.MethodGet, ts.URL+"/run/system/uptime", "", "")
+	sign("deploy", deploy)(r)
+	if resp, body := doClient(t, client, r); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected response to a signed request: %d %q", resp.StatusCode, body)
+	}
+	if resp, body := doClient(t, client, r); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unexpected response to a replayed request: %d %q", resp.StatusCode, body)
+	}
+
+	// the signature covers the query
+	r = request(t, http.MethodGet, ts.URL+"/run/system/uptime", "", "")
+	sign("deploy", deploy)(r)
+	r.URL.RawQuery = "format=text"
+	if resp, body := doClient(t, client, r); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unexpected response to a request whose query is modified: %d %q", resp.StatusCode, body)
+	}
+
+	// the timestamp is out of hmac_max_skew
+	r = request(t, http.MethodGet, ts.URL+"/run/system/uptime", "", "")
+	r.Header.Set(signing.ContentHashHeader, signing.HashBody(nil))
+	a := signing.Authorization{Key: "deploy", Timestamp: time.Now().Add(-time.Hour).Unix(), Nonce: "00ff"}
+	a.Signature = signing.Signature(deploy, signing.StringToSign(r, a.Timestamp, a.Nonce))
+	r.Header.Set("Authorization", a.String())
+	if resp, body := doClient(t, client, r); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unexpected response to a stale signature: %d %q", resp.StatusCode, body)
+	}
+
+	// the body hash is required
+	r.Header.Del(signing.ContentHashHeader)
+	a.Timestamp = time.Now().Unix()
+	a.Signature = signing.Signature(deploy, signing.StringToSign(r, a.Timestamp, a.Nonce))
+	r.Header.Set("Authorization", a.String())
+	if resp, body := doClient(t, client, r); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Unexpected response to a signature without body hash: %d %q", resp.StatusCode, body)
+	}
+
+	// the body is checked in full before the command is run
+	file := filepath.Join(t.TempDir(), "body")
+	r = request(t, http.MethodPost, ts.URL+"/run/system/save?file="+file, "hello", "")
+	sign("deploy", deploy)(r)
+	if resp, body := doClient(t, client, r); resp.StatusCode != http.StatusOK || harvest(t, body).Outcome != hangman.OutcomeSuccess {
+		t.Errorf("Unexpected response to a signed body: %d %q", resp.StatusCode, body)
+	}
+	if b, err := ioutil.ReadFile(file); err != nil || string(b) != "hello" {
+		t.Errorf("Signed body is saved as %q (%v), expected hello", string(b), err)
+	}
+	os.Remove(file)
+	r = request(t, http.MethodPost, ts.URL+"/run/system/save?file="+file, "hello", "")
+	sign("deploy", deploy)(r)
+	r.Body = ioutil.NopCloser(strings.NewReader("hellO"))
+	r.GetBody = nil
+	if resp, body := doClient(t, client, r); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Unexpected response to a tampered body: %d %q", resp.StatusCode, body)
+	}
+	// the request is rejected before the command is started
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("Command is run on a tampered body: %v", err)
+	}
+}
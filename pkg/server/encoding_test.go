@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/etombini/http-cmd/pkg/hangman"
+)
+
+func TestOutputEncoding(t *testing.T) {
+	ts := testServer(t, testConfig(t, "server"))
+
+	tests := []struct {
+		url      string
+		stdout   string
+		encoding string
+	}{
+		{"/run/system/echo?word=hello", "hello\n", hangman.EncodingUTF8},
+		{"/run/system/echo-base64?word=hello", base64.StdEncoding.EncodeToString([]byte("hello\n")), hangman.EncodingBase64},
+		{"/run/system/bytes", base64.StdEncoding.EncodeToString([]byte("\xffabc")), hangman.EncodingBase64},
+	}
+	for _, test := range tests {
+		resp, body := do(t, request(t, http.MethodGet, ts.URL+test.url, "", ""))
+		if h := harvest(t, body); h.Stdout != test.stdout || h.StdoutEncoding != test.encoding || h.StderrEncoding == "" {
+			t.Errorf("Unexpected output for %s: %d %q, expected %q in %s", test.url, resp.StatusCode, body, test.stdout, test.encoding)
+		}
+	}
+
+	// the raw output is not encoded
+	for _, accept := range []string{"application/x-bytes", "*/*;q=0.5, application/x-bytes"} {
+		r := request(t, http.MethodGet, ts.URL+"/run/system/bytes", "", "")
+		r.Header.Set("Accept", accept)
+		resp, body := do(t, r)
+		if resp.StatusCode != http.StatusOK || body != "\xffabc" || resp.Header.Get("Content-Type") != "application/x-bytes" {
+			t.Errorf("Unexpected binary response accepting %s: %d %s %q", accept, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+	}
+	resp, body := do(t, request(t, http.MethodGet, ts.URL+"/run/system/bytes?format=text", "", ""))
+	if resp.StatusCode != http.StatusOK || body != "\xffabc" {
+		t.Errorf("Unexpected text response: %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/etombini/http-cmd/pkg/config"
+	"github.com/etombini/http-cmd/pkg/hangman"
+)
+
+// Response formats of the exec handlers
+const (
+	// formatJSON answers with the whole Harvest as JSON
+	formatJSON string = "json"
+	// formatText answers with the raw stdout of the command as it is written,
+	// the status of the execution being given in trailers
+	formatText string = "text"
+	// formatBinary is like formatText, with the content type of the exec
+	formatBinary string = "binary"
+)
+
+// formatParameter is the query parameter selecting the response format, taking
+// precedence over the Accept header
+const formatParameter = "format"
+
+// Trailers holding the status of an execution in the text format
+const (
+	trailerOutcome        = "X-Outcome"
+	trailerExitCode       = "X-Exit-Code"
+	trailerSignal         = "X-Signal"
+	trailerPid            = "X-Pid"
+	trailerTimeoutReached = "X-Timeout-Reached"
+	// trailerStatus holds the status the status mapping of the exec gives
+	trailerStatus = "X-Status"
+)
+
+var formatMediaTypes = map[string]string{
+	"application/json": formatJSON,
+	"text/plain":       formatText,
+}
+
+// responseFormat returns the format a request is to be answered with, from the
+// format query parameter or else from the Accept header, JSON by default.
+// contentType is the content type of the binary format.
+func responseFormat(r *http.Request, contentType string) (string, error) {
+	if values, ok := r.URL.Query()[formatParameter]; ok {
+		switch format := values[0]; format {
+		case formatJSON, formatText, formatBinary:
+			return format, nil
+		default:
+			return "", requestError{http.StatusNotAcceptable,
+				"unknown format " + format + ", expecting " + formatJSON + ", " + formatText + " or " + formatBinary}
+		}
+	}
+	binaryType, _, _ := mime.ParseMediaType(contentType)
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatJSON, nil
+	}
+	format := ""
+	best := 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		f, ok := formatMediaTypes[mediaType]
+		if !ok && mediaType == binaryType {
+			f, ok = formatBinary, true
+		} else if !ok && (mediaType == "*/*" || mediaType == "application/*") {
+			f, ok = formatJSON, true
+		} else if !ok && mediaType == "text/*" {
+			f, ok = formatText, true
+		}
+		if ok && q > best {
+			format, best = f, q
+		}
+	}
+	if format == "" {
+		return "", requestError{http.StatusNotAcceptable, "the response can only be application/json, text/plain or " + binaryType}
+	}
+	return format, nil
+}
+
+// flushWriter is an io.Writer flushing a response after each write, so that
+// the output of a command is sent as soon as it is written
+type flushWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// Write never fails, so that the command is not stopped if the client is gone:
+// it is then cancelled.
+func (fw flushWriter) Write(p []byte) (int, error) {
+	if _, err := fw.w.Write(p); err == nil {
+		fw.flusher.Flush()
+	}
+	return len(p), nil
+}
+
+// reapRaw runs s, the sentence of exec, writing its stdout as the response
+// body of type contentType while it is running, then the status of the
+// execution in trailers. As the response status is sent before the command is
+// run, it is always 200, the status given by execStatus being sent in the
+// X-Status trailer instead.
+func reapRaw(w http.ResponseWriter, r *http.Request, exec config.Exec, s hangman.Sentence, contentType string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Trailer", strings.Join([]string{
+		trailerOutcome, trailerExitCode, trailerSignal, trailerPid, trailerTimeoutReached, trailerStatus}, ", "))
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.Stdout = flushWriter{w, flusher}
+	h := hangman.ReapContext(r.Context(), s)
+
+	w.Header().Set(trailerOutcome, h.Outcome)
+	w.Header().Set(trailerExitCode, strconv.Itoa(h.ExitCode))
+	w.Header().Set(trailerSignal, h.Signal)
+	w.Header().Set(trailerPid, strconv.Itoa(h.Pid))
+	w.Header().Set(trailerTimeoutReached, strconv.FormatBool(h.TimeoutReached))
+	w.Header().Set(trailerStatus, strconv.Itoa(execStatus(exec, s.Stdin, h)))
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/hangman"
+)
+
+func TestResponseFormat(t *testing.T) {
+	ts := testServer(t, testConfig(t, "server"))
+
+	tests := []struct {
+		query       string
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"", "", http.StatusOK, "application/json"},
+		{"", "*/*", http.StatusOK, "application/json"},
+		{"", "text/plain", http.StatusOK, "text/plain; charset=utf-8"},
+		{"", "text/*", http.StatusOK, "text/plain; charset=utf-8"},
+		{"", "text/plain;q=0.5, application/json", http.StatusOK, "application/json"},
+		{"", "application/octet-stream", http.StatusOK, "application/octet-stream"},
+		{"", "image/png", http.StatusNotAcceptable, ""},
+		{"&format=text", "application/json", http.StatusOK, "text/plain; charset=utf-8"},
+		{"&format=binary", "", http.StatusOK, "application/octet-stream"},
+		{"&format=json", "text/plain", http.StatusOK, "application/json"},
+		{"&format=xml", "", http.StatusNotAcceptable, ""},
+	}
+	for _, test := range tests {
+		r := request(t, http.MethodGet, ts.URL+"/run/system/echo?word=hello"+test.query, "", "")
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		resp, body := do(t, r)
+		if resp.StatusCode != test.status {
+			t.Errorf("Unexpected status for %q with Accept %q: %d instead of %d: %q", test.query, test.accept, resp.StatusCode, test.status, body)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != test.contentType {
+			t.Errorf("Unexpected content type for %q with Accept %q: %s instead of %s", test.query, test.accept, ct, test.contentType)
+			continue
+		}
+		if test.contentType == "application/json" {
+			if h := harvest(t, body); h.Stdout != "hello\n" {
+				t.Errorf("Unexpected harvest for %q with Accept %q: %q", test.query, test.accept, body)
+			}
+			continue
+		}
+		if body != "hello\n" || resp.Trailer.Get(trailerOutcome) != hangman.OutcomeSuccess ||
+			resp.Trailer.Get(trailerExitCode) != "0" || resp.Trailer.Get(trailerStatus) != "200" {
+			t.Errorf("Unexpected raw response for %q with Accept %q: %q, trailers %v", test.query, test.accept, body, resp.Trailer)
+		}
+	}
+
+	// the format parameter is not a parameter of the exec
+	resp, body := do(t, request(t, http.MethodPost, ts.URL+"/run/system/echo?format=text",
+		`{"parameters": {"word": "world"}}`, "application/json"))
+	if resp.StatusCode != http.StatusOK || body != "world\n" {
+		t.Errorf("Unexpected response to POST with a format: %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestRawWriteTimeout(t *testing.T) {
+	// the timeout of the exec exceeds the server write timeout
+	ts := newTestServer(t, testConfig(t, "server"))
+	ts.Config.WriteTimeout = time.Second
+	ts.Start()
+
+	resp, body := do(t, request(t, http.MethodGet, ts.URL+"/run/system/ticker?format=text", "", ""))
+	if body != "1\n2\n3\n" || resp.Trailer.Get(trailerOutcome) != hangman.OutcomeSuccess {
+		t.Errorf("Unexpected raw response past the server write timeout: %q, trailers %v", body, resp.Trailer)
+	}
+	resp, body = do(t, request(t, http.MethodGet, ts.URL+"/run/system/ticker", "", ""))
+	if h := harvest(t, body); resp.StatusCode != http.StatusOK || h.Stdout != "1\n2\n3\n" {
+		t.Errorf("Unexpected response past the server write timeout: %d %q", resp.StatusCode, body)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
 
 	"github.com/etombini/http-cmd/pkg/config"
 	"github.com/etombini/http-cmd/pkg/hangman"
@@ -17,24 +18,88 @@ type execHandler struct {
 
 type catalogHandler execHandler
 
+// execSentence returns the hangman.Sentence describing how to run an exec
+func execSentence(config config.Config, exec config.Exec) hangman.Sentence {
+	s := hangman.Sentence{
+		Command:        exec.Command,
+		Args:           exec.Args,
+		Timeout:        exec.Timeout,
+		KillGrace:      exec.KillGrace,
+		MaxOutputBytes: exec.MaxOutputBytes,
+		KeepTail:       exec.KeepTail,
+		OutputEncoding: exec.OutputEncoding,
+	}
+	if exec.Shell {
+		s.Shell = config.Server.Shell
+	}
+	if exec.Limits != nil {
+		s.Limits = exec.Limits.Hangman()
+	}
+	s.Dir = exec.Workdir
+	s.Env = execEnv(exec)
+	if exec.RunAs != nil {
+		s.Credential = &hangman.Credential{
+			UID:    exec.RunAs.UID,
+			GID:    exec.RunAs.GID,
+			Groups: exec.RunAs.Groups,
+		}
+	}
+	return s
+}
+
+// execEnv returns the environment of an exec, or nil if it inherits the whole
+// http-cmd environment. Without inherit_env, only the variables of
+// config.DefaultInheritEnv are inherited.
+func execEnv(exec config.Exec) []string {
+	inherit := exec.InheritEnv
+	if inherit == nil {
+		inherit = &config.InheritEnv{Allowlist: config.DefaultInheritEnv}
+	}
+	if len(exec.Env) == 0 && inherit.All {
+		return nil
+	}
+
+	env := make([]string, 0)
+	if inherit.All {
+		env = append(env, os.Environ()...)
+	} else {
+		for _, name := range inherit.Allowlist {
+			if value, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+value)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(exec.Env))
+	for name := range exec.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+exec.Env[name])
+	}
+	return env
+}
+
 // execHandlerGenerator returns a list of struct execHandler.
 // Each struct contains an URL and a function which is a http.Handler
-func execHandlerGenerator(config config.Config) []execHandler {
+// Async execs run as jobs in jobs when requested with POST.
+func execHandlerGenerator(config config.Config, jobs *jobStore, auth *auth) []execHandler {
 	ehs := make([]execHandler, 0)
 	for i := range config.Categories {
 		for j := range config.Categories[i].Execs {
 			pattern := new(string)
 			*pattern = config.Server.ExecPrefix + config.Categories[i].Name + "/" + config.Categories[i].Execs[j].Name
-			command := new(string)
-			*command = config.Categories[i].Execs[j].Command
-			timeout := new(uint32)
-			*timeout = config.Categories[i].Execs[j].Timeout
+			category := config.Categories[i].Name
+			exec := &config.Categories[i].Execs[j]
+			sentence := new(hangman.Sentence)
+			*sentence = execSentence(config, *exec)
 			handler := new(func(http.ResponseWriter, *http.Request))
 
 			// Generating the Handler func
 			*handler = func(w http.ResponseWriter, r *http.Request) {
-				if r.Method != "GET" {
-					http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+				if !methodAllowed(exec.Methods, r.Method) {
+					methodNotAllowed(w, exec.Methods)
 					return
 				}
 				if r.URL.Path != *pattern {
@@ -42,7 +107,64 @@ func execHandlerGenerator(config config.Config) []execHandler {
 					fmt.Fprintf(os.Stderr, "Invalid URL for command execution (got %s expecting %s)\n", r.URL.Path, *pattern)
 					return
 				}
-				h := hangman.Reaper(*command, *timeout)
+				if !auth.allowed(w, r, category, exec.Name) {
+					return
+				}
+				format, err := responseFormat(r, exec.ContentType)
+				if err != nil {
+					httpError(w, err)
+					return
+				}
+				parameters, stdin, err := execRequest(*exec, w, r)
+				if err != nil {
+					httpError(w, err)
+					return
+				}
+				if bodyStdin(*exec, r) {
+					defer endBody(w)
+				}
+				s := *sentence
+				s.Parameters = parameters
+				s.Stdin = stdin
+
+				if exec.Async && r.Method == http.MethodPost {
+					// the request body can not be read once the handler has returned
+					if _, spooled := stdin.(*os.File); stdin != nil && !spooled {
+						f, err := spool(stdin)
+						if err != nil {
+							httpError(w, err)
+							return
+						}
+						s.Stdin = f
+					}
+					job, err := jobs.start(category, exec.Name, exec.StatusMapping, s)
+					if err == errJobsFull {
+						httpError(w, requestError{http.StatusServiceUnavailable, err.Error()})
+						fmt.Fprintf(os.Stderr, "Can not start job for %s: %s\n", r.URL.Path, err.Error())
+						return
+					}
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						fmt.Fprintf(os.Stderr, "Error while starting job: %s\n", err.Error())
+						return
+					}
+					w.Header().Set("Location", config.Server.JobsPrefix+job.id)
+					writeJSON(w, http.StatusAccepted, jobs.json(job))
+					return
+				}
+				if f, ok := stdin.(*os.File); ok {
+					defer f.Close()
+				}
+				extendWriteDeadline(w, s)
+				switch format {
+				case formatText:
+					reapRaw(w, r, *exec, s, "text/plain; charset=utf-8")
+					return
+				case formatBinary:
+					reapRaw(w, r, *exec, s, exec.ContentType)
+					return
+				}
+				h := hangman.ReapContext(r.Context(), s)
 				js, err := json.Marshal(h)
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -50,6 +172,7 @@ func execHandlerGenerator(config config.Config) []execHandler {
 					return
 				}
 				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(execStatus(*exec, stdin, h))
 				w.Write(js)
 			}
 			eh := execHandler{pattern, handler}
@@ -60,10 +183,54 @@ func execHandlerGenerator(config config.Config) []execHandler {
 }
 
 type exec4JSON struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Command     string `json:"command"`
-	Timeout     uint32 `json:"timeout"`
+	Name             string           `json:"name"`
+	Description      string           `json:"description"`
+	Command          string           `json:"command,omitempty"`
+	Args             []string         `json:"args,omitempty"`
+	Shell            bool             `json:"shell"`
+	Timeout          uint32           `json:"timeout"`
+	KillGrace        uint32           `json:"kill_grace"`
+	Limits           *limits4JSON     `json:"limits,omitempty"`
+	RunAs            *runAs4JSON      `json:"run_as,omitempty"`
+	Workdir          string           `json:"workdir,omitempty"`
+	Env              []string         `json:"env,omitempty"`
+	InheritEnv       interface{}      `json:"inherit_env"`
+	Parameters       []parameter4JSON `json:"parameters,omitempty"`
+	Methods          []string         `json:"methods"`
+	Stdin            string           `json:"stdin"`
+	StdinMaxBytes    int64            `json:"stdin_max_bytes,omitempty"`
+	StdinContentType string           `json:"stdin_content_type,omitempty"`
+	Async            bool             `json:"async"`
+	Websocket        bool             `json:"websocket"`
+	PTY              bool             `json:"pty"`
+	OutputEncoding   string           `json:"output_encoding"`
+	ContentType      string           `json:"content_type"`
+}
+
+type parameter4JSON struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Type        string   `json:"type"`
+	Required    bool     `json:"required"`
+	Default     *string  `json:"default,omitempty"`
+	Values      []string `json:"values,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	AllowDash   bool     `json:"allow_dash"`
+}
+
+type runAs4JSON struct {
+	User                string   `json:"user"`
+	Group               string   `json:"group,omitempty"`
+	SupplementaryGroups []string `json:"supplementary_groups,omitempty"`
+}
+
+type limits4JSON struct {
+	CPU          *uint64 `json:"cpu,omitempty"`
+	AddressSpace *uint64 `json:"address_space,omitempty"`
+	OpenFiles    *uint64 `json:"open_files,omitempty"`
+	Processes    *uint64 `json:"processes,omitempty"`
+	FileSize     *uint64 `json:"file_size,omitempty"`
+	CoreSize     *uint64 `json:"core_size,omitempty"`
 }
 
 type execCatalog4JSON struct {
@@ -79,7 +246,7 @@ type catalog4JSON struct {
 
 // catalogHandlerGenerator returns a list of struct catalogHandler.
 // Each struct contains an URL and a function which is a http.Handler
-func catalogHandlerGenerator(config config.Config) []catalogHandler {
+func catalogHandlerGenerator(config config.Config, auth *auth) []catalogHandler {
 	chs := make([]catalogHandler, 0)
 
 	c4j := make([]catalog4JSON, 0)
@@ -91,8 +258,8 @@ func catalogHandlerGenerator(config config.Config) []catalogHandler {
 
 	// Generating the Handler func for the first catalog level
 	cHandler := func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" {
-			http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, []string{http.MethodGet})
 			return
 		}
 		if r.URL.Path != cPattern {
@@ -100,7 +267,19 @@ func catalogHandlerGenerator(config config.Config) []catalogHandler {
 			fmt.Fprintf(os.Stderr, "Invalid URL for command execution (%s)\n", r.URL.Path)
 			return
 		}
-		js, err := json.Marshal(c4j)
+		// categories the client is not allowed to run anything of are hidden
+		grant, err := auth.grant(r)
+		if err != nil {
+			auth.unauthorized(w, err)
+			return
+		}
+		visible := make([]catalog4JSON, 0)
+		for _, c := range c4j {
+			if grant.AllowsCategory(c.Name) {
+				visible = append(visible, c)
+			}
+		}
+		js, err := json.Marshal(visible)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			fmt.Fprintf(os.Stderr, "Error while generating global catalog\n")
@@ -113,20 +292,74 @@ func catalogHandlerGenerator(config config.Config) []catalogHandler {
 	chs = append(chs, ch)
 
 	for i := range config.Categories {
+		category := config.Categories[i].Name
 		ecPattern := config.Server.CatalogPrefix + config.Categories[i].Name
 		e4j := make([]exec4JSON, 0)
 		for j := range config.Categories[i].Execs {
-			e := exec4JSON{config.Categories[i].Execs[j].Name,
-				config.Categories[i].Execs[j].Description,
-				config.Categories[i].Execs[j].Command,
-				config.Categories[i].Execs[j].Timeout}
+			e := exec4JSON{
+				Name:             config.Categories[i].Execs[j].Name,
+				Description:      config.Categories[i].Execs[j].Description,
+				Command:          config.Categories[i].Execs[j].Command,
+				Args:             config.Categories[i].Execs[j].Args,
+				Shell:            config.Categories[i].Execs[j].Shell,
+				Timeout:          config.Categories[i].Execs[j].Timeout,
+				KillGrace:        config.Categories[i].Execs[j].KillGrace,
+				Methods:          config.Categories[i].Execs[j].Methods,
+				Stdin:            config.Categories[i].Execs[j].Stdin,
+				StdinMaxBytes:    config.Categories[i].Execs[j].StdinMaxBytes,
+				StdinContentType: config.Categories[i].Execs[j].StdinContentType,
+				Async:            config.Categories[i].Execs[j].Async,
+				Websocket:        config.Categories[i].Execs[j].Websocket,
+				PTY:              config.Categories[i].Execs[j].PTY,
+				OutputEncoding:   config.Categories[i].Execs[j].OutputEncoding,
+				ContentType:      config.Categories[i].Execs[j].ContentType,
+			}
+			if l := config.Categories[i].Execs[j].Limits; l != nil {
+				e.Limits = &limits4JSON{
+					CPU:          l.CPU,
+					AddressSpace: l.AddressSpace,
+					OpenFiles:    l.OpenFiles,
+					Processes:    l.Processes,
+					FileSize:     l.FileSize,
+					CoreSize:     l.CoreSize,
+				}
+			}
+			if r := config.Categories[i].Execs[j].RunAs; r != nil {
+				e.RunAs = &runAs4JSON{r.User, r.Group, r.SupplementaryGroups}
+			}
+			e.Workdir = config.Categories[i].Execs[j].Workdir
+			// only variable names are shown, values may be sensitive
+			for name := range config.Categories[i].Execs[j].Env {
+				e.Env = append(e.Env, name)
+			}
+			sort.Strings(e.Env)
+			// inherit_env is always set once the configuration is loaded
+			if ie := config.Categories[i].Execs[j].InheritEnv; ie != nil && ie.All {
+				e.InheritEnv = true
+			} else if ie != nil && ie.Allowlist == nil {
+				e.InheritEnv = false
+			} else if ie != nil {
+				e.InheritEnv = ie.Allowlist
+			}
+			for _, p := range config.Categories[i].Execs[j].Parameters {
+				e.Parameters = append(e.Parameters, parameter4JSON{
+					Name:        p.Name,
+					Description: p.Description,
+					Type:        p.Type,
+					Required:    p.Default == nil,
+					Default:     p.Default,
+					Values:      p.Values,
+					Pattern:     p.Pattern,
+					AllowDash:   p.AllowDash,
+				})
+			}
 			e4j = append(e4j, e)
 		}
 
 		// Generating the Handler func for each catalog category
 		ecHandler := func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != "GET" {
-				http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+			if r.Method != http.MethodGet {
+				methodNotAllowed(w, []string{http.MethodGet})
 				return
 			}
 			if r.URL.Path != ecPattern {
@@ -134,7 +367,23 @@ func catalogHandlerGenerator(config config.Config) []catalogHandler {
 				fmt.Fprintf(os.Stderr, "Invalid URL for exec catalog (%s)\n", r.URL.Path)
 				return
 			}
-			js, err := json.Marshal(e4j)
+			// execs the client is not allowed to run are hidden
+			grant, err := auth.grant(r)
+			if err != nil {
+				auth.unauthorized(w, err)
+				return
+			}
+			if !grant.AllowsCategory(category) {
+				http.NotFound(w, r)
+				return
+			}
+			visible := make([]exec4JSON, 0)
+			for _, e := range e4j {
+				if grant.Allows(category, e.Name) {
+					visible = append(visible, e)
+				}
+			}
+			js, err := json.Marshal(visible)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				fmt.Fprintf(os.Stderr, "Error while generating execs catalog\n")
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExecMethodNotAllowed(t *testing.T) {
+	ts := testServer(t, testConfig(t, "server"))
+
+	resp, _ := do(t, request(t, http.MethodDelete, ts.URL+"/run/system/echo?word=hello", "", ""))
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, POST" {
+		t.Errorf("Unexpected response to DELETE: %d, Allow: %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	resp, _ = do(t, request(t, http.MethodPost, ts.URL+"/catalog/", "", ""))
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET" {
+		t.Errorf("Unexpected response to POST on the catalog: %d, Allow: %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	resp, _ = do(t, request(t, http.MethodGet, ts.URL+"/run/system/echo/more", "", ""))
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Unexpected response to an unknown exec: %d", resp.StatusCode)
+	}
+}
+
+func TestExecJSONBody(t *testing.T) {
+	ts := testServer(t, testConfig(t, "server"))
+
+	resp, body := do(t, request(t, http.MethodGet, ts.URL+"/run/system/echo?word=hello", "", ""))
+	if resp.StatusCode != http.StatusOK || harvest(t, body).Stdout != "hello\n" {
+		t.Errorf("Unexpected response to GET: %d %q", resp.StatusCode, body)
+	}
+	resp, body = do(t, request(t, http.MethodPost, ts.URL+"/run/system/echo",
+		`{"parameters": {"word": "world"}}`, "application/json"))
+	if resp.StatusCode != http.StatusOK || harvest(t, body).Stdout != "world\n" {
+		t.Errorf("Unexpected response to POST: %d %q", resp.StatusCode, body)
+	}
+	resp, body = do(t, request(t, http.MethodPost, ts.URL+"/run/system/echo",
+		`{"parameters": {"word": 42}}`, "application/json"))
+	if resp.StatusCode != http.StatusOK || harvest(t, body).Stdout != "42\n" {
+		t.Errorf("Unexpected response to POST with a number: %d %q", resp.StatusCode, body)
+	}
+
+	tests := []struct {
+		body        string
+		contentType string
+		status      int
+	}{
+		{`{"parameters": {"word": "world"}}`, "text/plain", http.StatusUnsupportedMediaType},
+		{`{"parameters": {"word": "world"`, "application/json", http.StatusBadRequest},
+		{`{"parameters": {"word": ["a", "b"]}}`, "application/json", http.StatusBadRequest},
+		{`{"parameters": {"other": "world"}}`, "application/json", http.StatusBadRequest},
+		{`{"parameters": {"word": "-rf"}}`, "application/json", http.StatusBadRequest},
+		{`{"stdin": "world"}`, "application/json", http.StatusBadRequest},
+		{`{"parameters": {"word": "` + strings.Repeat("a", int(maxJSONBodyBytes)) + `"}}`, "application/json", http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		resp, body := do(t, request(t, http.MethodPost, ts.URL+"/run/system/echo", test.body, test.contentType))
+		if resp.StatusCode != test.status {
+			t.Errorf("Unexpected status for POST of %.40q as %s: %d instead of %d: %q", test.body, test.contentType, resp.StatusCode, test.status, body)
+		}
+	}
+}
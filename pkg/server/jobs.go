@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/config"
+	"github.com/etombini/http-cmd/pkg/hangman"
+)
+
+// JobRunning is the status of a job which is not finished yet. Once finished,
+// the status of a job is the outcome of its execution.
+const JobRunning string = "running"
+
+// job is an execution run asynchronously
+type job struct {
+	id       string
+	category string
+	exec     string
+	created  time.Time
+	finished time.Time
+	harvest  hangman.Harvest
+	status   *config.StatusMapping
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+type job4JSON struct {
+	ID         string     `json:"id"`
+	Category   string     `json:"category"`
+	Exec       string     `json:"exec"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	URL        string     `json:"url"`
+}
+
+// errJobsFull is returned when max jobs are running
+var errJobsFull = errors.New("too many jobs are running")
+
+// jobStore keeps jobs in memory, until retention after they are finished. At
+// most max jobs are kept: the oldest finished job is dropped to make room for a
+// new one, and no job is started while max jobs are running.
+type jobStore struct {
+	ctx       context.Context
+	prefix    string
+	retention time.Duration
+	max       int
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// newJobStore returns a jobStore. Jobs are cancelled when ctx is done. Finished
+// jobs are purged periodically, until ctx is done.
+func newJobStore(ctx context.Context, config config.Config) *jobStore {
+	js := &jobStore{
+		ctx:       ctx,
+		prefix:    config.Server.JobsPrefix,
+		retention: time.Second * time.Duration(config.Server.JobRetention),
+		max:       int(config.Server.MaxJobs),
+		jobs:      make(map[string]*job),
+	}
+	go func() {
+		interval := time.Minute
+		if js.retention < interval {
+			interval = js.retention
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				js.mu.Lock()
+				js.purge()
+				js.mu.Unlock()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return js
+}
+
+// newJobID returns a random job identifier
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// purge removes the jobs finished for longer than retention. It must be called with mu held.
+func (js *jobStore) purge() {
+	now := time.Now()
+	for id, j := range js.jobs {
+		select {
+		case <-j.done:
+			if now.Sub(j.finished) > js.retention {
+				delete(js.jobs, id)
+			}
+		default:
+		}
+	}
+}
+
+// evict drops the oldest finished job. It returns false if all jobs are
+// running. It must be called with mu held.
+func (js *jobStore) evict() bool {
+	var oldest *job
+	for _, j := range js.jobs {
+		select {
+		case <-j.done:
+			if oldest == nil || j.finished.Before(oldest.finished) {
+				oldest = j
+			}
+		default:
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(js.jobs, oldest.id)
+	return true
+}
+
+// start runs s in the background and returns the new job, or errJobsFull if
+// max jobs are running. The harvest of the job is answered with the status
+// status gives. s.Stdin is closed once the job is finished, or if it can not be
+// started, if it is an io.Closer.
+func (js *jobStore) start(category string, exec string, status *config.StatusMapping, s hangman.Sentence) (*job, error) {
+	closeStdin := func() {
+		if c, ok := s.Stdin.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	id, err := newJobID()
+	if err != nil {
+		closeStdin()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(js.ctx)
+	j := &job{
+		id:       id,
+		category: category,
+		exec:     exec,
+		created:  time.Now(),
+		status:   status,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	js.mu.Lock()
+	js.purge()
+	if len(js.jobs) >= js.max && !js.evict() {
+		js.mu.Unlock()
+		cancel()
+		closeStdin()
+		return nil, errJobsFull
+	}
+	js.jobs[id] = j
+	js.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		h := hangman.ReapContext(ctx, s)
+		closeStdin()
+		js.mu.Lock()
+		j.harvest = h
+		j.finished = time.Now()
+		js.mu.Unlock()
+		close(j.done)
+	}()
+	return j, nil
+}
+
+// get returns a job by its identifier, or nil
+func (js *jobStore) get(id string) *job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.purge()
+	return js.jobs[id]
+}
+
+// json returns the status of a job
+func (js *jobStore) json(j *job) job4JSON {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j4j := job4JSON{
+		ID:        j.id,
+		Category:  j.category,
+		Exec:      j.exec,
+		Status:    JobRunning,
+		CreatedAt: j.created,
+		URL:       js.prefix + j.id,
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		j4j.Status = j.harvest.Outcome
+		j4j.FinishedAt = &finished
+	}
+	return j4j
+}
+
+// writeJSON answers a request with v as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		fmt.Fprintf(os.Stderr, "Error while converting to json: %+v\n", v)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// jobsHandlerGenerator returns the execHandler for the jobs URLs:
+//
+//	GET    <jobs_prefix><id>          the status of a job
+//	GET    <jobs_prefix><id>/harvest  the result of a finished job
+//	DELETE <jobs_prefix><id>          cancels a job
+func jobsHandlerGenerator(config config.Config, jobs *jobStore, auth *auth) execHandler {
+	pattern := new(string)
+	*pattern = config.Server.JobsPrefix
+	handler := new(func(http.ResponseWriter, *http.Request))
+
+	*handler = func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, *pattern)
+		id := strings.TrimSuffix(path, "/harvest")
+		harvest := id != path
+		if id == "" || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			fmt.Fprintf(os.Stderr, "Invalid URL for job (%s)\n", r.URL.Path)
+			return
+		}
+
+		methods := []string{http.MethodGet, http.MethodDelete}
+		if harvest {
+			methods = []string{http.MethodGet}
+		}
+		if !methodAllowed(methods, r.Method) {
+			methodNotAllowed(w, methods)
+			return
+		}
+
+		j := jobs.get(id)
+		if j == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !auth.allowed(w, r, j.category, j.exec) {
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodDelete:
+			j.cancel()
+			writeJSON(w, http.StatusAccepted, jobs.json(j))
+		case harvest:
+			select {
+			case <-j.done:
+				writeJSON(w, j.status.Status(j.harvest), j.harvest)
+			default:
+				http.Error(w, "409 conflict: job "+id+" is still running", http.StatusConflict)
+			}
+		default:
+			writeJSON(w, http.StatusOK, jobs.json(j))
+		}
+	}
+	return execHandler{pattern, handler}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/hangman"
+)
+
+// startJob starts a job and returns its URL
+func startJob(t *testing.T, ts string, url string, body string, contentType string) string {
+	resp, body := do(t, request(t, http.MethodPost, ts+url, body, contentType))
+	var j job4JSON
+	if err := json.Unmarshal([]byte(body), &j); err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Unexpected response to starting a job: %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Location") != j.URL || !strings.HasPrefix(j.URL, "/jobs/") || j.Status != JobRunning {
+		t.Fatalf("Unexpected job at %q: %+v", resp.Header.Get("Location"), j)
+	}
+	return j.URL
+}
+
+// waitJob waits for a job to be finished and returns its status
+func waitJob(t *testing.T, url string) job4JSON {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		resp, body := do(t, request(t, http.MethodGet, url, "", ""))
+		var j job4JSON
+		if err := json.Unmarshal([]byte(body), &j); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected response to the job status: %d %q", resp.StatusCode, body)
+		}
+		if j.Status != JobRunning {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job %s is still running", j.ID)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	ts := testServer(t, testConfig(t, "server"))
+
+	url := startJob(t, ts.URL, "/run/system/sleep?seconds=0", "", "")
+	if j := waitJob(t, ts.URL+url); j.Status != hangman.OutcomeSuccess || j.FinishedAt == nil {
+		t.Errorf("Unexpected finished job: %+v", j)
+	}
+	resp, body := do(t, request(t, http.MethodGet, ts.URL+url+"/harvest", "", ""))
+	if h := harvest(t, body); resp.StatusCode != http.StatusOK || h.Outcome != hangman.OutcomeSuccess {
+		t.Errorf("Unexpected harvest of a finished job: %d %q", resp.StatusCode, body)
+	}
+
+	url = startJob(t, ts.URL, "/run/system/sleep?seconds=5", "", "")
+	resp, body = do(t, request(t, http.MethodGet, ts.URL+url+"/harvest", "", ""))
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Unexpected harvest of a running job: %d %q", resp.StatusCode, body)
+	}
+	resp, body = do(t, request(t, http.MethodDelete, ts.URL+url, "", ""))
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Unexpected response to cancelling a job: %d %q", resp.StatusCode, body)
+	}
+	if j := waitJob(t, ts.URL+url); j.Status != hangman.OutcomeCancelled {
+		t.Errorf("Unexpected cancelled job: %+v", j)
+	}
+
+	url = startJob(t, ts.URL, "/run/system/cat-async", "hello", "text/plain")
+	waitJob(t, ts.URL+url)
+	resp, body = do(t, request(t, http.MethodGet, ts.URL+url+"/harvest", "", ""))
+	if h := harvest(t, body); h.Stdout != "hello" {
+		t.Errorf("Unexpected harvest of a job reading the request body: %d %q", resp.StatusCode, body)
+	}
+	resp, body = do(t, request(t, http.MethodPost, ts.URL+"/run/system/cat-async", strings.Repeat("a", 17), "text/plain"))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Unexpected response to a job body too large: %d %q", resp.StatusCode, body)
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		status int
+	}{
+		{http.MethodGet, "/jobs/unknown", http.StatusNotFound},
+		{http.MethodGet, "/jobs/unknown/more", http.StatusNotFound},
+		{http.MethodPost, url, http.StatusMethodNotAllowed},
+		{http.MethodDelete, url + "/harvest", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		resp, body := do(t, request(t, test.method, ts.URL+test.url, "", ""))
+		if resp.StatusCode != test.status {
+			t.Errorf("Unexpected status for %s %s: %d instead of %d: %q", test.method, test.url, resp.StatusCode, test.status, body)
+		}
+	}
+}
+
+func TestJobsFull(t *testing.T) {
+	cfg := testConfig(t, "server")
+	cfg.Server.MaxJobs = 1
+	ts := testServer(t, cfg)
+
+	url := startJob(t, ts.URL, "/run/system/sleep?seconds=5", "", "")
+	resp, body := do(t, request(t, http.MethodPost, ts.URL+"/run/system/sleep?seconds=0", "", ""))
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected response to a job while max jobs are running: %d %q", resp.StatusCode, body)
+	}
+
+	// a finished job is dropped to make room for a new one
+	do(t, request(t, http.MethodDelete, ts.URL+url, "", ""))
+	waitJob(t, ts.URL+url)
+	startJob(t, ts.URL, "/run/system/sleep?seconds=0", "", "")
+	if resp, _ := do(t, request(t, http.MethodGet, ts.URL+url, "", "")); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Unexpected status of a dropped job: %d", resp.StatusCode)
+	}
+}
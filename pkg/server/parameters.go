@@ -0,0 +1,47 @@
+package server
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/etombini/http-cmd/pkg/config"
+)
+
+// execParameters returns the value of each parameter of an exec, taken from
+// values or from the parameter default. Unknown parameters, repeated ones, missing
+// required ones and invalid values are rejected.
+func execParameters(exec config.Exec, values map[string][]string) (map[string]string, error) {
+	declared := make(map[string]bool)
+	for _, p := range exec.Parameters {
+		declared[p.Name] = true
+	}
+	unknown := make([]string, 0)
+	for name := range values {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, errors.New("unknown parameter " + unknown[0])
+	}
+
+	parameters := make(map[string]string)
+	for _, p := range exec.Parameters {
+		v, ok := values[p.Name]
+		switch {
+		case !ok && p.Default == nil:
+			return nil, errors.New("missing parameter " + p.Name)
+		case !ok:
+			parameters[p.Name] = *p.Default
+			continue
+		case len(v) != 1:
+			return nil, errors.New("parameter " + p.Name + " must be given once")
+		}
+		if err := p.Validate(v[0]); err != nil {
+			return nil, err
+		}
+		parameters[p.Name] = v[0]
+	}
+	return parameters, nil
+}
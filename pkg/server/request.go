@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/config"
+	"github.com/etombini/http-cmd/pkg/hangman"
+	"github.com/etombini/http-cmd/pkg/signing"
+)
+
+// maxJSONBodyBytes is the maximum size of a JSON request body
+const maxJSONBodyBytes int64 = 1024 * 1024
+
+// execJSONBody is the JSON body of a POST request running an exec
+type execJSONBody struct {
+	Parameters map[string]interface{} `json:"parameters"`
+	Stdin      *string                `json:"stdin"`
+}
+
+// requestError is an error while reading a request, carrying the HTTP status to answer with
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e requestError) Error() string {
+	return e.message
+}
+
+func badRequest(message string) requestError {
+	return requestError{http.StatusBadRequest, message}
+}
+
+// methodAllowed tells whether method is part of methods
+func methodAllowed(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// methodNotAllowed answers a request with a 405 status and the list of allowed methods
+func methodNotAllowed(w http.ResponseWriter, methods []string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+}
+
+// httpError answers a request with the status carried by err, or 400
+func httpError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if e, ok := err.(requestError); ok {
+		status = e.status
+	}
+	http.Error(w, strconv.Itoa(status)+" "+strings.ToLower(http.StatusText(status))+": "+err.Error(), status)
+}
+
+// execRequest reads the parameters of an exec and the standard input of the
+// command from a request. Parameters are taken from the query and, for a POST
+// request, from the JSON body unless the body is streamed to the command. The
+// format query parameter selects the response format and is left out. The
+// body of a signed request is not streamed but spooled to a file, returned as
+// the standard input for the caller to close, so that it is checked against
+// its signed hash before the command reads any of it.
+func execRequest(exec config.Exec, w http.ResponseWriter, r *http.Request) (map[string]string, io.Reader, error) {
+	values := r.URL.Query()
+	values.Del(formatParameter)
+	var stdin io.Reader
+
+	if bodyStdin(exec, r) {
+		if exec.StdinContentType != "" {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != exec.StdinContentType {
+				return nil, nil, requestError{http.StatusUnsupportedMediaType, "request body must be " + exec.StdinContentType}
+			}
+		}
+		if r.ContentLength > exec.StdinMaxBytes {
+			return nil, nil, requestError{http.StatusRequestEntityTooLarge,
+				"request body is larger than " + strconv.FormatInt(exec.StdinMaxBytes, 10) + " bytes"}
+		}
+		// the command output may be written while the body is still read,
+		// HTTP/2 requests are always full duplex
+		http.NewResponseController(w).EnableFullDuplex()
+		stdin = &bodyReader{body: http.MaxBytesReader(w, r.Body, exec.StdinMaxBytes)}
+	} else if r.Method == http.MethodPost && r.ContentLength != 0 {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			return nil, nil, requestError{http.StatusUnsupportedMediaType, "request body must be application/json"}
+		}
+		var body execJSONBody
+		reader := http.MaxBytesReader(w, r.Body, maxJSONBodyBytes)
+		decoder := json.NewDecoder(reader)
+		decoder.UseNumber()
+		if err := decoder.Decode(&body); err != nil && err != io.EOF {
+			if _, ok := err.(*http.MaxBytesError); ok {
+				return nil, nil, requestError{http.StatusRequestEntityTooLarge, err.Error()}
+			}
+			return nil, nil, badRequest("invalid JSON body: " + err.Error())
+		}
+		// the body is read to its end, so that it is checked against its signature
+		if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+			if _, ok := err.(*http.MaxBytesError); ok {
+				return nil, nil, requestError{http.StatusRequestEntityTooLarge, err.Error()}
+			}
+			return nil, nil, badRequest("invalid JSON body: " + err.Error())
+		}
+
+		for name, v := range body.Parameters {
+			switch value := v.(type) {
+			case string:
+				values[name] = append(values[name], value)
+			case json.Number:
+				values[name] = append(values[name], value.String())
+			case bool:
+				values[name] = append(values[name], strconv.FormatBool(value))
+			default:
+				return nil, nil, badRequest("parameter " + name + " must be a string, a number or a boolean")
+			}
+		}
+
+		if body.Stdin != nil {
+			if exec.Stdin != config.StdinJSON {
+				return nil, nil, badRequest("exec " + exec.Name + " does not accept stdin")
+			}
+			if int64(len(*body.Stdin)) > exec.StdinMaxBytes {
+				return nil, nil, requestError{http.StatusRequestEntityTooLarge,
+					"stdin is larger than " + strconv.FormatInt(exec.StdinMaxBytes, 10) + " bytes"}
+			}
+			stdin = bytes.NewBufferString(*body.Stdin)
+		}
+	}
+
+	parameters, err := execParameters(exec, values)
+	if err != nil {
+		return nil, nil, badRequest(err.Error())
+	}
+	if bodyStdin(exec, r) && r.Header.Get(signing.ContentHashHeader) != "" {
+		f, err := spool(stdin)
+		if err != nil {
+			return nil, nil, err
+		}
+		stdin = f
+	}
+	return parameters, stdin, nil
+}
+
+// bodyReader is a request body streamed to a command, keeping the error its
+// reading failed with
+type bodyReader struct {
+	body io.Reader
+
+	mu  sync.Mutex
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		if b.err == nil {
+			b.err = err
+		}
+		b.mu.Unlock()
+	}
+	return n, err
+}
+
+// execStatus returns the status answering h, the execution of exec with the
+// standard input stdin, as given by the status mapping of exec. If stdin is a
+// request body which could not be read, the command having run on a partial
+// input, the status is 413 if the body is too large and 400 otherwise.
+func execStatus(exec config.Exec, stdin io.Reader, h hangman.Harvest) int {
+	if b, ok := stdin.(*bodyReader); ok {
+		b.mu.Lock()
+		err := b.err
+		b.mu.Unlock()
+		if _, ok := err.(*http.MaxBytesError); ok {
+			return http.StatusRequestEntityTooLarge
+		}
+		if err != nil {
+			return http.StatusBadRequest
+		}
+	}
+	return exec.StatusMapping.Status(h)
+}
+
+// bodyStdin tells whether the body of r is streamed to the command of exec
+func bodyStdin(exec config.Exec, r *http.Request) bool {
+	return r.Method == http.MethodPost && exec.Stdin == config.StdinRequestBody
+}
+
+// endBody stops reading the request body streamed to a command once the command
+// has ended, so that a client stalling its body does not hold the response
+func endBody(w http.ResponseWriter) {
+	http.NewResponseController(w).SetReadDeadline(time.Now())
+}
+
+// spool copies the standard input of a job or of a signed request to an unnamed
+// temporary file, so that it is not held in memory. The returned file is read from its beginning.
+func spool(stdin io.Reader) (*os.File, error) {
+	f, err := ioutil.TempFile("", "http-cmd-stdin-")
+	if err != nil {
+		return nil, requestError{http.StatusInternalServerError, "can not store the standard input: " + err.Error()}
+	}
+	// the file is removed once closed
+	os.Remove(f.Name())
+	if _, err := io.Copy(f, stdin); err != nil {
+		f.Close()
+		if _, ok := err.(*http.MaxBytesError); ok {
+			return nil, requestError{http.StatusRequestEntityTooLarge, err.Error()}
+		}
+		return nil, badRequest("can not read the standard input: " + err.Error())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, requestError{http.StatusInternalServerError, "can not store the standard input: " + err.Error()}
+	}
+	return f, nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/hangman"
+)
+
+func TestExecStdin(t *testing.T) {
+	ts := testServer(t, testConfig(t, "server"))
+
+	resp, body := do(t, request(t, http.MethodPost, ts.URL+"/run/system/cat", "hello", "text/plain"))
+	if resp.StatusCode != http.StatusOK || harvest(t, body).Stdout != "hello" {
+		t.Errorf("Unexpected response to a streamed body: %d %q", resp.StatusCode, body)
+	}
+	resp, body = do(t, request(t, http.MethodPost, ts.URL+"/run/system/cat-json", `{"stdin": "hello"}`, "application/json"))
+	if resp.StatusCode != http.StatusOK || harvest(t, body).Stdout != "hello" {
+		t.Errorf("Unexpected response to stdin in a JSON body: %d %q", resp.StatusCode, body)
+	}
+
+	tests := []struct {
+		exec        string
+		body        string
+		contentType string
+		status      int
+	}{
+		{"cat", "hello", "application/json", http.StatusUnsupportedMediaType},
+		{"cat", strings.Repeat("a", 17), "text/plain", http.StatusRequestEntityTooLarge},
+		{"cat-json", `{"stdin": "` + strings.Repeat("a", 17) + `"}`, "application/json", http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		resp, body := do(t, request(t, http.MethodPost, ts.URL+"/run/system/"+test.exec, test.body, test.contentType))
+		if resp.StatusCode != test.status {
+			t.Errorf("Unexpected status for %s with %.40q as %s: %d instead of %d: %q", test.exec, test.body, test.contentType, resp.StatusCode, test.status, body)
+		}
+	}
+
+	// without a content length, the body is only found too large while it is read
+	r := request(t, http.MethodPost, ts.URL+"/run/system/cat", "", "text/plain")
+	r.Body = io.NopCloser(strings.NewReader(strings.Repeat("a", 17)))
+	resp, body = do(t, r)
+	if h := harvest(t, body); resp.StatusCode != http.StatusRequestEntityTooLarge || h.Outcome != hangman.OutcomeCancelled || h.IOError == "" {
+		t.Errorf("Unexpected response to a chunked body too large: %d %q", resp.StatusCode, body)
+	}
+	r = request(t, http.MethodPost, ts.URL+"/run/system/cat?format=text", "", "text/plain")
+	r.Body = io.NopCloser(strings.NewReader(strings.Repeat("a", 17)))
+	resp, body = do(t, r)
+	if resp.Trailer.Get(trailerStatus) != strconv.Itoa(http.StatusRequestEntityTooLarge) {
+		t.Errorf("Unexpected raw response to a chunked body too large: %q, trailers %v", body, resp.Trailer)
+	}
+}
+
+func TestExecStatusBody(t *testing.T) {
+	exec := testConfig(t, "server").Categories[0].Execs[0]
+	h := hangman.Harvest{Outcome: hangman.OutcomeCancelled}
+
+	// the body failing to be read, the command ran on a partial input
+	b := &bodyReader{body: iotest.TimeoutReader(strings.NewReader("hello"))}
+	ioutil.ReadAll(b)
+	if status := execStatus(exec, b, h); status != http.StatusBadRequest {
+		t.Errorf("Status is %d for a body which could not be read, expected %d", status, http.StatusBadRequest)
+	}
+	b = &bodyReader{body: strings.NewReader("hello")}
+	ioutil.ReadAll(b)
+	if status := execStatus(exec, b, h); status != http.StatusOK {
+		t.Errorf("Status is %d for a body read to its end, expected %d", status, http.StatusOK)
+	}
+}
+
+func TestExecStdinStalled(t *testing.T) {
+	ts := testServer(t, testConfig(t, "server"))
+
+	// the body is never ended, the exec times out after 1 second
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := request(t, http.MethodPost, ts.URL+"/run/system/cat", "", "text/plain")
+	r.Body = pr
+	go pw.Write([]byte("hello"))
+	start := time.Now()
+	resp, body := do(t, r)
+	if h := harvest(t, body); !h.TimeoutReached || h.Stdout != "hello" {
+		t.Errorf("Unexpected response to a stalled body: %d %q", resp.StatusCode, body)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("Stalled body kept the exec running for %s", d)
+	}
+}
@@ -1,50 +1,121 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/etombini/http-cmd/pkg/config"
+	"github.com/etombini/http-cmd/pkg/hangman"
 )
 
-func getHandler(config config.Config) http.Handler {
+// getHandler returns the http.Handler serving the catalog, the execs, their
+// streaming and interactive variants and the jobs.
+// Jobs are cancelled when ctx is done.
+func getHandler(ctx context.Context, config config.Config) http.Handler {
 	m := http.NewServeMux()
+	auth := newAuth(config)
 
-	ch := catalogHandlerGenerator(config)
+	ch := catalogHandlerGenerator(config, auth)
 	for i := range ch {
 		m.HandleFunc(*ch[i].pattern, *ch[i].handler)
 	}
 
-	eh := execHandlerGenerator(config)
+	jobs := newJobStore(ctx, config)
+	eh := execHandlerGenerator(config, jobs, auth)
 	for i := range eh {
 		m.HandleFunc(*eh[i].pattern, *eh[i].handler)
 	}
 
+	sh := streamHandlerGenerator(config, auth)
+	for i := range sh {
+		m.HandleFunc(*sh[i].pattern, *sh[i].handler)
+	}
+
+	wh := websocketHandlerGenerator(config, auth)
+	for i := range wh {
+		m.HandleFunc(*wh[i].pattern, *wh[i].handler)
+	}
+
+	jh := jobsHandlerGenerator(config, jobs, auth)
+	m.HandleFunc(*jh.pattern, *jh.handler)
+
 	return m
 }
 
-func getServer(config config.Config) *http.Server {
+// writeTimeoutSlack is the time given to write a response on top of the time
+// the execution may take
+const writeTimeoutSlack = time.Second * 5
+
+// extendWriteDeadline gives the response running s the time its execution may
+// take, the timeout of an exec exceeding the server one WriteTimeout is set from
+func extendWriteDeadline(w http.ResponseWriter, s hangman.Sentence) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(s.MaxDuration() + writeTimeoutSlack)); err != nil {
+		fmt.Fprintf(os.Stderr, "Can not extend the write deadline of the response: %s\n", err.Error())
+	}
+}
+
+// getServer returns the http.Server. Requests contexts are derived from ctx,
+// so that cancelling it terminates all running commands.
+func getServer(ctx context.Context, config config.Config) *http.Server {
 
 	var s http.Server
 	s.Addr = config.Server.Address
 	s.ReadHeaderTimeout = time.Second * 3
-	s.WriteTimeout = time.Second * time.Duration(config.Server.Timeout+5)
-	s.Handler = getHandler(config)
+	s.WriteTimeout = time.Second*time.Duration(config.Server.Timeout) + writeTimeoutSlack
+	s.Handler = getHandler(ctx, config)
+	s.BaseContext = func(net.Listener) context.Context { return ctx }
 	return &s
 }
 
-// Run starts the server using proper configuration
+// Run starts the server using proper configuration, with TLS if configured.
+// On SIGINT or SIGTERM, running commands are terminated and the server is shut down.
 func Run(config config.Config) {
-	server := getServer(config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := getServer(ctx, config)
 
 	listener, err := net.Listen("tcp", config.Server.Address+":"+strconv.Itoa(int(config.Server.Port)))
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can not listen on %s:%d: %s\n", config.Server.Address, config.Server.Port, err.Error())
 		os.Exit(1)
 	}
 
-	server.Serve(listener)
+	stopped := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		s := <-sig
+		fmt.Fprintf(os.Stderr, "Received %s, shutting down\n", s)
+		cancel()
+		// running commands may use their kill grace period before handlers return
+		sCtx, sCancel := context.WithTimeout(context.Background(), time.Second*time.Duration(config.Server.Timeout+5))
+		defer sCancel()
+		if err := server.Shutdown(sCtx); err != nil {
+			fmt.Fprintf(os.Stderr, "Error while shutting down: %s\n", err.Error())
+		}
+		close(stopped)
+	}()
 
+	if config.Server.TLS.Enabled() {
+		if server.TLSConfig, err = getTLSConfig(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Can not configure TLS: %s\n", err.Error())
+			os.Exit(1)
+		}
+		// the certificate is given by TLSConfig.GetCertificate
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "Error while serving: %s\n", err.Error())
+		os.Exit(1)
+	}
+	<-stopped
 }
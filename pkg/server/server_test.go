@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/etombini/http-cmd/pkg/config"
+	"github.com/etombini/http-cmd/pkg/hangman"
+)
+
+// testConfig loads the configuration of test-scripts/config/<name>
+func testConfig(t *testing.T, name string) config.Config {
+	configFile := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/" + name + "/http-cmd.yaml"
+	cfg, err := config.New(configFile)
+	if err != nil {
+		t.Fatal("Error while creating Config: " + err.Error())
+	}
+	return *cfg
+}
+
+// newTestServer returns a server configured as getServer does, serving cfg
+// until the end of the test once started
+func newTestServer(t *testing.T, cfg config.Config) *httptest.Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = getServer(ctx, cfg)
+	t.Cleanup(func() {
+		ts.Close()
+		cancel()
+	})
+	return ts
+}
+
+// testServer serves cfg until the end of the test
+func testServer(t *testing.T, cfg config.Config) *httptest.Server {
+	ts := newTestServer(t, cfg)
+	ts.Start()
+	return ts
+}
+
+// testTLSServer serves cfg over TLS until the end of the test
+func testTLSServer(t *testing.T, cfg config.Config) *httptest.Server {
+	tlsConfig, err := getTLSConfig(cfg)
+	if err != nil {
+		t.Fatal("Can not configure TLS: " + err.Error())
+	}
+	ts := newTestServer(t, cfg)
+	ts.Listener = tls.NewListener(ts.Listener, tlsConfig)
+	ts.Start()
+	ts.URL = "https" + strings.TrimPrefix(ts.URL, "http")
+	return ts
+}
+
+// testTLSClient returns a client trusting the test server certificate, and
+// presenting the client certificate test-scripts/config/auth/<cert>.pem if
+// cert is set
+func testTLSClient(t *testing.T, cert string) *http.Client {
+	dir := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/"
+	pem, err := ioutil.ReadFile(dir + "tls/cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	c := &tls.Config{RootCAs: roots}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(dir+"auth/"+cert+".pem", dir+"auth/"+cert+"-key.pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Certificates = []tls.Certificate{pair}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: c}}
+}
+
+// do sends r and returns the response with its body read
+func do(t *testing.T, r *http.Request) (*http.Response, string) {
+	return doClient(t, http.DefaultClient, r)
+}
+
+// doClient sends r with client and returns the response with its body read
+func doClient(t *testing.T, client *http.Client, r *http.Request) (*http.Response, string) {
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+// request returns a new request, failing the test if it can not be created
+func request(t *testing.T, method string, url string, body string, contentType string) *http.Request {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+// harvest decodes the Harvest of a JSON response
+func harvest(t *testing.T, body string) hangman.Harvest {
+	var h hangman.Harvest
+	if err := json.Unmarshal([]byte(body), &h); err != nil {
+		t.Fatalf("Response is not a Harvest: %s: %q", err.Error(), body)
+	}
+	return h
+}
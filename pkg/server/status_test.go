@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestStatusMapping(t *testing.T) {
+	ts := testServer(t, testConfig(t, "server"))
+
+	tests := []struct {
+		code   int
+		status int
+	}{
+		{0, http.StatusOK},
+		{1, http.StatusInternalServerError},
+		{3, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		url := "/run/system/exit?code=" + strconv.Itoa(test.code)
+		resp, body := do(t, request(t, http.MethodGet, ts.URL+url, "", ""))
+		if resp.StatusCode != test.status || harvest(t, body).ExitCode != test.code {
+			t.Errorf("Unexpected response for exit code %d: %d instead of %d: %q", test.code, resp.StatusCode, test.status, body)
+		}
+
+		// the raw output is answered before the command ends
+		resp, body = do(t, request(t, http.MethodGet, ts.URL+url+"&format=text", "", ""))
+		if resp.StatusCode != http.StatusOK || resp.Trailer.Get(trailerStatus) != strconv.Itoa(test.status) {
+			t.Errorf("Unexpected raw response for exit code %d: %d, X-Status %q instead of %d", test.code, resp.StatusCode, resp.Trailer.Get(trailerStatus), test.status)
+		}
+
+		resp, body = do(t, request(t, http.MethodGet, ts.URL+"/stream/system/exit?code="+strconv.Itoa(test.code), "", ""))
+		var exit exitStatus
+		if e := events(body); len(e[eventExit]) != 1 || json.Unmarshal([]byte(e[eventExit][0]), &exit) != nil || exit.Status != test.status {
+			t.Errorf("Unexpected exit event for exit code %d: %d %q", test.code, resp.StatusCode, body)
+		}
+
+		job := startJob(t, ts.URL, url, "", "")
+		waitJob(t, ts.URL+job)
+		resp, body = do(t, request(t, http.MethodGet, ts.URL+job+"/harvest", "", ""))
+		if resp.StatusCode != test.status || harvest(t, body).ExitCode != test.code {
+			t.Errorf("Unexpected job harvest for exit code %d: %d instead of %d: %q", test.code, resp.StatusCode, test.status, body)
+		}
+	}
+
+	// the status mapping of the server applies to the execs without one
+	ts = testServer(t, testConfig(t, "status-mapping"))
+	resp, body := do(t, request(t, http.MethodGet, ts.URL+"/run/system/check", "", ""))
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Unexpected status with the strict status mapping: %d: %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/etombini/http-cmd/pkg/config"
+	"github.com/etombini/http-cmd/pkg/hangman"
+)
+
+// eventExit is the last Server-Sent Event of a streamed execution, holding the
+// exit status as JSON. It follows a stdout or stderr event for each line written
+// by the command.
+const eventExit string = "exit"
+
+// exitStatus is a Harvest without the outputs, which have already been sent.
+// Status is the HTTP status execStatus gives, the response status being sent
+// before the execution ends.
+type exitStatus struct {
+	hangman.Harvest
+	Status int `json:"status"`
+	// shadow the outputs of the Harvest, left out as they are nil
+	Stdout         *string `json:"stdout,omitempty"`
+	Stderr         *string `json:"stderr,omitempty"`
+	StdoutEncoding *string `json:"stdout_encoding,omitempty"`
+	StderrEncoding *string `json:"stderr_encoding,omitempty"`
+}
+
+// writeEvent writes a Server-Sent Event, data being split on as many data lines as needed
+func writeEvent(w io.Writer, event string, data string) error {
+	var b strings.Builder
+	b.WriteString("event: " + event + "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// streamHandlerGenerator returns a list of struct execHandler running the
+// execs like execHandlerGenerator does, the outputs of the command being sent
+// as Server-Sent Events while it is running.
+func streamHandlerGenerator(config config.Config, auth *auth) []execHandler {
+	ehs := make([]execHandler, 0)
+	for i := range config.Categories {
+		for j := range config.Categories[i].Execs {
+			pattern := new(string)
+			*pattern = config.Server.StreamPrefix + config.Categories[i].Name + "/" + config.Categories[i].Execs[j].Name
+			category := config.Categories[i].Name
+			exec := &config.Categories[i].Execs[j]
+			sentence := new(hangman.Sentence)
+			*sentence = execSentence(config, *exec)
+			handler := new(func(http.ResponseWriter, *http.Request))
+
+			*handler = func(w http.ResponseWriter, r *http.Request) {
+				if !methodAllowed(exec.Methods, r.Method) {
+					methodNotAllowed(w, exec.Methods)
+					return
+				}
+				if r.URL.Path != *pattern {
+					http.NotFound(w, r)
+					fmt.Fprintf(os.Stderr, "Invalid URL for command streaming (got %s expecting %s)\n", r.URL.Path, *pattern)
+					return
+				}
+				if !auth.allowed(w, r, category, exec.Name) {
+					return
+				}
+				flusher, ok := w.(http.Flusher)
+				if !ok {
+					http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+					fmt.Fprintf(os.Stderr, "Streaming is not supported by the response writer\n")
+					return
+				}
+				parameters, stdin, err := execRequest(*exec, w, r)
+				if err != nil {
+					httpError(w, err)
+					return
+				}
+				if bodyStdin(*exec, r) {
+					defer endBody(w)
+				}
+				if f, ok := stdin.(*os.File); ok {
+					defer f.Close()
+				}
+				s := *sentence
+				s.Parameters = parameters
+				s.Stdin = stdin
+
+				extendWriteDeadline(w, s)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Header().Set("Cache-Control", "no-cache")
+				// prevents reverse proxies from buffering the events
+				w.Header().Set("X-Accel-Buffering", "no")
+				w.WriteHeader(http.StatusOK)
+				flusher.Flush()
+
+				h := hangman.ReapStream(r.Context(), s, func(l hangman.Line) {
+					// the client may be gone, the command is then cancelled through the request context
+					if writeEvent(w, l.Stream, l.Text) == nil {
+						flusher.Flush()
+					}
+				})
+				js, err := json.Marshal(exitStatus{Harvest: h, Status: execStatus(*exec, stdin, h)})
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error while converting execution result to json: %+v", h)
+					return
+				}
+				writeEvent(w, eventExit, string(js))
+				flusher.Flush()
+			}
+			ehs = append(ehs, execHandler{pattern, handler})
+		}
+	}
+	return ehs
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/hangman"
+)
+
+// events parses a Server-Sent Events body into the data of the events by name
+func events(body string) map[string][]string {
+	e := make(map[string][]string)
+	for _, block := range strings.Split(strings.TrimSuffix(body, "\n\n"), "\n\n") {
+		var name string
+		var data []string
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = append(data, strings.TrimPrefix(line, "data: "))
+			}
+		}
+		e[name] = append(e[name], strings.Join(data, "\n"))
+	}
+	return e
+}
+
+func TestStream(t *testing.T) {
+	ts := testServer(t, testConfig(t, "server"))
+
+	resp, body := do(t, request(t, http.MethodGet, ts.URL+"/stream/system/lines", "", ""))
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response to streaming: %d %s %q", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	e := events(body)
+	if len(e["stdout"]) != 1 || e["stdout"][0] != "out" || len(e["stderr"]) != 1 || e["stderr"][0] != "err" {
+		t.Errorf("Unexpected output events: %q", body)
+	}
+	if len(e[eventExit]) != 1 {
+		t.Fatalf("Unexpected exit events: %q", body)
+	}
+	var exit map[string]interface{}
+	if err := json.Unmarshal([]byte(e[eventExit][0]), &exit); err != nil {
+		t.Fatalf("Exit event is not JSON: %s: %q", err.Error(), e[eventExit][0])
+	}
+	if exit["outcome"] != hangman.OutcomeFailure || exit["exit_code"] != float64(3) || exit["status"] != float64(http.StatusOK) {
+		t.Errorf("Unexpected exit event: %q", e[eventExit][0])
+	}
+	for _, output := range []string{"stdout", "stderr", "stdout_encoding", "stderr_encoding"} {
+		if _, ok := exit[output]; ok {
+			t.Errorf("Exit event holds the output %s: %q", output, e[eventExit][0])
+		}
+	}
+
+	// the body is read while the events are sent
+	resp, body = do(t, request(t, http.MethodPost, ts.URL+"/stream/system/cat", "hello\nworld\n", "text/plain"))
+	if e := events(body); strings.Join(e["stdout"], ",") != "hello,world" {
+		t.Errorf("Unexpected events for a streamed request body: %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestStreamWriteTimeout(t *testing.T) {
+	// the timeout of the exec exceeds the server write timeout
+	ts := newTestServer(t, testConfig(t, "server"))
+	ts.Config.WriteTimeout = time.Second
+	ts.Start()
+
+	resp, body := do(t, request(t, http.MethodGet, ts.URL+"/stream/system/ticker", "", ""))
+	if e := events(body); strings.Join(e["stdout"], ",") != "1,2,3" || len(e[eventExit]) != 1 {
+		t.Errorf("Unexpected events past the server write timeout: %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/config"
+)
+
+// certCheckInterval is the minimum delay between two checks of the certificate files
+const certCheckInterval = time.Second * 10
+
+// certReloader gives the certificate of the server, reloading it when the
+// modification time of the certificate or key file changes, so that rotated
+// certificates are used without restarting. If the new files can not be
+// loaded, the previous certificate is kept.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// lastModified returns the latest modification time of the certificate and key files
+func (cr *certReloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, f := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return last, err
+		}
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	return last, nil
+}
+
+// reload loads the certificate and key files. It must be called with mu held.
+func (cr *certReloader) reload() error {
+	modTime, err := cr.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if time.Since(cr.checked) < certCheckInterval {
+		return cr.cert, nil
+	}
+	cr.checked = time.Now()
+	modTime, err := cr.lastModified()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can not check certificate files, keeping the current certificate: %s\n", err.Error())
+		return cr.cert, nil
+	}
+	// files replaced by older ones are reloaded too
+	if modTime.Equal(cr.modTime) {
+		return cr.cert, nil
+	}
+	if err := cr.reload(); err != nil {
+		fmt.Fprintf(os.Stderr, "Can not reload certificate, keeping the current one: %s\n", err.Error())
+		return cr.cert, nil
+	}
+	fmt.Fprintf(os.Stderr, "Certificate reloaded from %s\n", cr.certFile)
+	return cr.cert, nil
+}
+
+// getTLSConfig returns the TLS configuration of the server, verifying clients
+// certificates if a client CA is configured. A certificate is required unless
+// clients can authenticate otherwise, with an API key, a password or a signature.
+func getTLSConfig(config config.Config) (*tls.Config, error) {
+	cr, err := newCertReloader(config.Server.TLS.Cert, config.Server.TLS.Key)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{
+		MinVersion:     config.Server.TLS.MinVersion,
+		CipherSuites:   config.Server.TLS.CipherSuiteIDs,
+		GetCertificate: cr.GetCertificate,
+	}
+	if config.Server.TLS.ClientAuth() {
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+		if len(config.Server.APIKeys) > 0 || config.Server.Htpasswd.Enabled() || len(config.Server.HMACKeys) > 0 {
+			c.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		c.ClientCAs = config.Server.TLS.ClientCAs
+	}
+	return c, nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLS(t *testing.T) {
+	ts := testTLSServer(t, testConfig(t, "tls"))
+
+	resp, body := doClient(t, testTLSClient(t, ""), request(t, http.MethodGet, ts.URL+"/catalog/", "", ""))
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil || resp.TLS.Version != tls.VersionTLS13 {
+		t.Errorf("Unexpected response over TLS: %d %+v %q", resp.StatusCode, resp.TLS, body)
+	}
+
+	// tls_min_version is 1.3
+	client := testTLSClient(t, "")
+	client.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS12
+	if _, err := client.Do(request(t, http.MethodGet, ts.URL+"/catalog/", "", "")); err == nil {
+		t.Error("TLS 1.2 client is accepted")
+	}
+	// the certificate is verified
+	if _, err := http.DefaultClient.Do(request(t, http.MethodGet, ts.URL+"/catalog/", "", "")); err == nil {
+		t.Error("Server certificate is not verified")
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := os.Getenv("GOPATH") + "/src/github.com/etombini/http-cmd/test-scripts/config/"
+	tmp := t.TempDir()
+	certFile := filepath.Join(tmp, "cert.pem")
+	keyFile := filepath.Join(tmp, "key.pem")
+	copyFile := func(src string, dst string, modTime time.Time) {
+		b, err := ioutil.ReadFile(dir + src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dst, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(dst, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	copyFile("tls/cert.pem", certFile, now.Add(-time.Hour))
+	copyFile("tls/key.pem", keyFile, now.Add(-time.Hour))
+
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("Can not load certificate: " + err.Error())
+	}
+	first, _ := cr.GetCertificate(nil)
+
+	// a certificate which can not be loaded is not used
+	copyFile("auth/alice.pem", certFile, now.Add(-time.Minute))
+	cr.checked = time.Time{}
+	if cert, _ := cr.GetCertificate(nil); cert != first {
+		t.Error("Certificate not matching its key is used")
+	}
+
+	// files are checked at most every certCheckInterval
+	copyFile("auth/alice-key.pem", keyFile, now)
+	if cert, _ := cr.GetCertificate(nil); cert != first {
+		t.Error("Certificate files are checked again before certCheckInterval")
+	}
+	cr.checked = time.Time{}
+	cert, _ := cr.GetCertificate(nil)
+	if cert == first {
+		t.Fatal("Modified certificate is not reloaded")
+	}
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err != nil || leaf.Subject.CommonName != "alice" {
+		t.Errorf("Reloaded certificate is not alice: %+v %v", leaf, err)
+	}
+
+	// files replaced by older ones, keeping their modification time, are reloaded too
+	copyFile("tls/cert.pem", certFile, now.Add(-2*time.Hour))
+	copyFile("tls/key.pem", keyFile, now.Add(-2*time.Hour))
+	cr.checked = time.Time{}
+	cert, _ = cr.GetCertificate(nil)
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err != nil || leaf.Subject.CommonName != "localhost" {
+		t.Errorf("Certificate replaced by an older one is not reloaded: %+v %v", leaf, err)
+	}
+}
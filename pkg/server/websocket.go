@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/config"
+	"github.com/etombini/http-cmd/pkg/hangman"
+	"github.com/gorilla/websocket"
+)
+
+// Types of the JSON messages exchanged over a WebSocket. The client sends stdin,
+// eof and resize messages, binary messages being written verbatim on stdin. The
+// server sends stdout and stderr messages while the command is running, then an
+// exit message holding the exit status before closing the connection.
+const (
+	wsStdin  string = "stdin"
+	wsEOF    string = "eof"
+	wsResize string = "resize"
+	wsStdout string = hangman.StreamStdout
+	wsStderr string = hangman.StreamStderr
+	wsExit   string = "exit"
+)
+
+// wsCloseTimeout is the time given to send the close message
+const wsCloseTimeout = time.Second * 5
+
+// wsMaxPendingStdin is the maximum number of bytes received from the client and
+// not read yet by the command. Beyond it, the connection is dropped.
+const wsMaxPendingStdin = 1024 * 1024
+
+type wsMessage struct {
+	Type    string      `json:"type"`
+	Data    string      `json:"data,omitempty"`
+	Rows    uint16      `json:"rows,omitempty"`
+	Cols    uint16      `json:"cols,omitempty"`
+	Harvest *exitStatus `json:"harvest,omitempty"`
+}
+
+// wsConn serializes the messages sent on a WebSocket
+type wsConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *wsConn) send(m wsMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(m)
+}
+
+// wsWriter is an io.Writer sending what is written to it as messages of a type
+type wsWriter struct {
+	conn   *wsConn
+	stream string
+}
+
+// Write never fails, so that the command is not stopped if the client is gone:
+// it is then cancelled.
+func (w wsWriter) Write(p []byte) (int, error) {
+	w.conn.send(wsMessage{Type: w.stream, Data: string(p)})
+	return len(p), nil
+}
+
+// wsSize reads the initial size of a terminal from the rows and cols query
+// parameters, and removes them from the query of r
+func wsSize(r *http.Request) hangman.WindowSize {
+	var size hangman.WindowSize
+	values := r.URL.Query()
+	if rows, err := strconv.ParseUint(values.Get("rows"), 10, 16); err == nil {
+		size.Rows = uint16(rows)
+	}
+	if cols, err := strconv.ParseUint(values.Get("cols"), 10, 16); err == nil {
+		size.Cols = uint16(cols)
+	}
+	values.Del("rows")
+	values.Del("cols")
+	r.URL.RawQuery = values.Encode()
+	return size
+}
+
+// wsRead reads the messages sent by the client until the connection is closed,
+// queueing stdin to be written to w and giving the new sizes of the terminal to
+// resize. Stdin is written to w by another goroutine, so that the messages, the
+// close message in particular, are still read while the command does not read
+// its standard input. It returns if more than wsMaxPendingStdin bytes are
+// pending.
+func wsRead(conn *websocket.Conn, w *os.File, resize chan<- hangman.WindowSize) {
+	defer close(resize)
+	var pending int64
+	queue := make(chan []byte, 64)
+	go func() {
+		// a write fails once the command is done, its stdin being closed
+		defer w.Close()
+		for data := range queue {
+			w.Write(data)
+			atomic.AddInt64(&pending, -int64(len(data)))
+		}
+	}()
+	closed := false
+	closeQueue := func() {
+		if !closed {
+			close(queue)
+			closed = true
+		}
+	}
+	defer closeQueue()
+	write := func(data []byte) bool {
+		if closed || len(data) == 0 {
+			return true
+		}
+		if atomic.AddInt64(&pending, int64(len(data))) > wsMaxPendingStdin {
+			fmt.Fprintf(os.Stderr, "WebSocket client sent more than %d bytes not read by the command\n", wsMaxPendingStdin)
+			return false
+		}
+		select {
+		case queue <- data:
+			return true
+		default:
+			fmt.Fprintf(os.Stderr, "WebSocket client sent too many messages not read by the command\n")
+			return false
+		}
+	}
+
+	for {
+		t, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if t == websocket.BinaryMessage {
+			if !write(data) {
+				return
+			}
+			continue
+		}
+		var m wsMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid WebSocket message: %s\n", err.Error())
+			continue
+		}
+		switch m.Type {
+		case wsStdin:
+			if !write([]byte(m.Data)) {
+				return
+			}
+		case wsEOF:
+			closeQueue()
+		case wsResize:
+			select {
+			case resize <- hangman.WindowSize{Rows: m.Rows, Cols: m.Cols}:
+			default:
+			}
+		}
+	}
+}
+
+// websocketHandlerGenerator returns a list of struct execHandler running the
+// execs allowing it interactively: the standard input and outputs of the
+// command are bridged to a WebSocket, through a pseudo-terminal if set.
+// Parameters are given in the query of the upgrade request, as well as the
+// initial size of the terminal (rows and cols).
+func websocketHandlerGenerator(config config.Config, auth *auth) []execHandler {
+	ehs := make([]execHandler, 0)
+	upgrader := websocket.Upgrader{HandshakeTimeout: time.Second * 3}
+	for i := range config.Categories {
+		for j := range config.Categories[i].Execs {
+			if !config.Categories[i].Execs[j].Websocket {
+				continue
+			}
+			pattern := new(string)
+			*pattern = config.Server.WebsocketPrefix + config.Categories[i].Name + "/" + config.Categories[i].Execs[j].Name
+			category := config.Categories[i].Name
+			exec := &config.Categories[i].Execs[j]
+			sentence := new(hangman.Sentence)
+			*sentence = execSentence(config, *exec)
+			handler := new(func(http.ResponseWriter, *http.Request))
+
+			*handler = func(w http.ResponseWriter, r *http.Request) {
+				// the opening handshake is a GET request, which the exec must allow
+				if r.Method != http.MethodGet || !methodAllowed(exec.Methods, r.Method) {
+					methodNotAllowed(w, []string{http.MethodGet})
+					return
+				}
+				if r.URL.Path != *pattern {
+					http.NotFound(w, r)
+					fmt.Fprintf(os.Stderr, "Invalid URL for interactive command execution (got %s expecting %s)\n", r.URL.Path, *pattern)
+					return
+				}
+				if !auth.allowed(w, r, category, exec.Name) {
+					return
+				}
+				var size hangman.WindowSize
+				if exec.PTY {
+					size = wsSize(r)
+				}
+				parameters, _, err := execRequest(*exec, w, r)
+				if err != nil {
+					httpError(w, err)
+					return
+				}
+				// the upgrader answers the request itself on error
+				c, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Can not upgrade to WebSocket: %s\n", err.Error())
+					return
+				}
+				defer c.Close()
+				conn := &wsConn{conn: c}
+
+				// closing the read end once the command is done ends the write of
+				// pending stdin
+				stdin, stdinWriter, err := os.Pipe()
+				if err != nil {
+					conn.send(wsMessage{Type: wsStderr, Data: err.Error()})
+					fmt.Fprintf(os.Stderr, "Can not create stdin pipe: %s\n", err.Error())
+					return
+				}
+				defer stdin.Close()
+				resize := make(chan hangman.WindowSize, 1)
+				ctx, cancel := context.WithCancel(r.Context())
+				defer cancel()
+				go func() {
+					wsRead(c, stdinWriter, resize)
+					// the client is gone
+					cancel()
+				}()
+
+				s := *sentence
+				s.Parameters = parameters
+				s.Stdin = stdin
+				s.Stdout = wsWriter{conn, wsStdout}
+				s.Stderr = wsWriter{conn, wsStderr}
+				if exec.PTY {
+					s.Terminal = &hangman.Terminal{Size: size, Resize: resize}
+				}
+
+				// the messages are written with their own deadline once the connection is hijacked
+				c.SetWriteDeadline(time.Now().Add(s.MaxDuration() + writeTimeoutSlack))
+				h := hangman.ReapContext(ctx, s)
+				conn.send(wsMessage{Type: wsExit, Harvest: &exitStatus{Harvest: h, Status: exec.StatusMapping.Status(h)}})
+				c.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "exit code "+strconv.Itoa(h.ExitCode)),
+					time.Now().Add(wsCloseTimeout))
+			}
+			ehs = append(ehs, execHandler{pattern, handler})
+		}
+	}
+	return ehs
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/etombini/http-cmd/pkg/hangman"
+	"github.com/gorilla/websocket"
+)
+
+func TestWebsocket(t *testing.T) {
+	ts := testServer(t, testConfig(t, "server"))
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/system/cat-ws"
+
+	c, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Can not open WebSocket: %s", err.Error())
+	}
+	defer c.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Unexpected response to the opening handshake: %d", resp.StatusCode)
+	}
+	c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if err := c.WriteJSON(wsMessage{Type: wsStdin, Data: "hello "}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteMessage(websocket.BinaryMessage, []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteJSON(wsMessage{Type: wsEOF}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout string
+	var exit *exitStatus
+	for exit == nil {
+		var m wsMessage
+		if err := c.ReadJSON(&m); err != nil {
+			t.Fatalf("Can not read WebSocket message: %s", err.Error())
+		}
+		switch m.Type {
+		case wsStdout:
+			stdout += m.Data
+		case wsExit:
+			exit = m.Harvest
+		}
+	}
+	if stdout != "hello world" {
+		t.Errorf("Stdout is %q, expected %q", stdout, "hello world")
+	}
+	if exit.Outcome != hangman.OutcomeSuccess || exit.Status != http.StatusOK || exit.Stdout != nil {
+		t.Errorf("Unexpected exit message: %+v", exit)
+	}
+	if _, _, err := c.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Connection is closed with %v, expected a normal closure", err)
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		status int
+	}{
+		{http.MethodPost, "/ws/system/cat-ws", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/ws/system/cat-ws", http.StatusBadRequest},
+		{http.MethodGet, "/ws/system/echo", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		resp, body := do(t, request(t, test.method, ts.URL+test.url, "", ""))
+		if resp.StatusCode != test.status {
+			t.Errorf("Unexpected status for %s %s: %d instead of %d: %q", test.method, test.url, resp.StatusCode, test.status, body)
+		}
+	}
+}
+
+func TestWebsocketStdinPending(t *testing.T) {
+	ts := testServer(t, testConfig(t, "server"))
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/system/sleep-ws"
+
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Can not open WebSocket: %s", err.Error())
+	}
+	defer c.Close()
+	// more than a pipe holds, the command never reading it
+	if err := c.WriteMessage(websocket.BinaryMessage, make([]byte, 256*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := c.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("Connection is closed with %v, expected the close message to be answered", err)
+			}
+			break
+		}
+	}
+}
+
+func TestWebsocketWriteTimeout(t *testing.T) {
+	// the timeout of the exec exceeds the server write timeout
+	ts := newTestServer(t, testConfig(t, "server"))
+	ts.Config.WriteTimeout = time.Second
+	ts.Start()
+
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws/system/ticker", nil)
+	if err != nil {
+		t.Fatalf("Can not open WebSocket: %s", err.Error())
+	}
+	defer c.Close()
+	var stdout string
+	for {
+		var m wsMessage
+		if err := c.ReadJSON(&m); err != nil {
+			t.Fatalf("Can not read WebSocket message after %q: %s", stdout, err.Error())
+		}
+		if m.Type == wsStdout {
+			stdout += m.Data
+		}
+		if m.Type == wsExit {
+			break
+		}
+	}
+	if stdout != "1\n2\n3\n" {
+		t.Errorf("Stdout is %q past the server write timeout", stdout)
+	}
+}
@@ -0,0 +1,143 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Scheme is the scheme of the Authorization header of a signed request
+const Scheme string = "HMAC-SHA256"
+
+// ContentHashHeader is the header holding the hex encoded SHA-256 of the body
+// of a signed request. The header is signed rather than the body itself, so
+// that the signature is checked before the body is read.
+const ContentHashHeader string = "X-Content-SHA256"
+
+// ErrContentHash is returned when reading a body which does not match its
+// ContentHashHeader
+var ErrContentHash = errors.New("request body does not match its " + ContentHashHeader + " header")
+
+// Authorization holds the parameters of the Authorization header of a signed
+// request: the name of the shared secret, the Unix time the request was signed
+// at, a random nonce used once, and the signature.
+type Authorization struct {
+	Key       string
+	Timestamp int64
+	Nonce     string
+	Signature string
+}
+
+// String returns the Authorization header value
+func (a Authorization) String() string {
+	return Scheme + ` key="` + a.Key + `", timestamp="` + strconv.FormatInt(a.Timestamp, 10) +
+		`", nonce="` + a.Nonce + `", signature="` + a.Signature + `"`
+}
+
+// ParseAuthorization parses the Authorization header value of a signed
+// request. It returns false if the value is not of the Scheme scheme, and an
+// error if it is but can not be parsed.
+func ParseAuthorization(value string) (Authorization, bool, error) {
+	var a Authorization
+	if len(value) <= len(Scheme) || !strings.EqualFold(value[:len(Scheme)+1], Scheme+" ") {
+		return a, false, nil
+	}
+	for _, param := range strings.Split(value[len(Scheme)+1:], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return a, true, errors.New("malformed authorization parameter " + param)
+		}
+		v := strings.Trim(kv[1], `"`)
+		switch kv[0] {
+		case "key":
+			a.Key = v
+		case "timestamp":
+			t, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return a, true, errors.New("malformed timestamp " + v)
+			}
+			a.Timestamp = t
+		case "nonce":
+			a.Nonce = v
+		case "signature":
+			a.Signature = v
+		}
+	}
+	if a.Key == "" || a.Timestamp == 0 || a.Nonce == "" || a.Signature == "" {
+		return a, true, errors.New("key, timestamp, nonce and signature are required")
+	}
+	return a, true, nil
+}
+
+// HashBody returns the ContentHashHeader value of body
+func HashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidContentHash tells whether value is a well formed ContentHashHeader value
+func ValidContentHash(value string) bool {
+	b, err := hex.DecodeString(value)
+	return err == nil && len(b) == sha256.Size
+}
+
+// StringToSign returns what is signed for a request: its method, host in lower
+// case, escaped path, query sorted by key, ContentHashHeader, timestamp and
+// nonce, one per line. The host is the one the client sends in the Host header,
+// a proxy forwarding the request must keep it.
+func StringToSign(r *http.Request, timestamp int64, nonce string) string {
+	host := r.Host
+	if host == "" && r.URL.Host != "" {
+		host = r.URL.Host
+	}
+	return strings.Join([]string{
+		r.Method,
+		strings.ToLower(host),
+		r.URL.EscapedPath(),
+		r.URL.Query().Encode(),
+		strings.ToLower(r.Header.Get(ContentHashHeader)),
+		strconv.FormatInt(timestamp, 10),
+		nonce,
+	}, "\n")
+}
+
+// Signature returns the hex encoded HMAC-SHA256 of stringToSign with secret
+func Signature(secret []byte, stringToSign string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyingReader hashes a body while it is read, and checks the hash once
+// the body is read to its end
+type verifyingReader struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+// VerifyBody returns a reader of body failing with ErrContentHash at its end
+// if it does not match contentHash, a ContentHashHeader value. The body is
+// given to the reader as it is read, before being verified.
+func VerifyBody(body io.ReadCloser, contentHash string) io.ReadCloser {
+	return &verifyingReader{body: body, hash: sha256.New(), expected: strings.ToLower(contentHash)}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.body.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && !hmac.Equal([]byte(hex.EncodeToString(v.hash.Sum(nil))), []byte(v.expected)) {
+		return n, ErrContentHash
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.body.Close()
+}
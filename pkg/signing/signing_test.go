@@ -0,0 +1,69 @@
+package signing_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/etombini/http-cmd/pkg/signing"
+)
+
+func TestParseAuthorization(t *testing.T) {
+	tests := []struct {
+		value string
+		ok    bool
+		err   bool
+	}{
+		{`Bearer 0123`, false, false},
+		{`Basic YWxpY2U6cGFzc3dvcmQ=`, false, false},
+		{`HMAC-SHA256 key="deploy", timestamp="1700000000", nonce="00ff", signature="abcd"`, true, false},
+		{`HMAC-SHA256 key="deploy", timestamp="now", nonce="00ff", signature="abcd"`, true, true},
+		{`HMAC-SHA256 key="deploy", timestamp="1700000000"`, true, true},
+		{`HMAC-SHA256 key`, true, true},
+	}
+	for _, test := range tests {
+		_, ok, err := signing.ParseAuthorization(test.value)
+		if ok != test.ok || (err != nil) != test.err {
+			t.Errorf("ParseAuthorization(%q) is (%t, %v), expected (%t, error %t)", test.value, ok, err, test.ok, test.err)
+		}
+	}
+
+	a := signing.Authorization{Key: "deploy", Timestamp: 1700000000, Nonce: "00ff", Signature: "abcd"}
+	if b, ok, err := signing.ParseAuthorization(a.String()); !ok || err != nil || b != a {
+		t.Errorf("ParseAuthorization(%q) is (%+v, %t, %v), expected %+v", a.String(), b, ok, err, a)
+	}
+}
+
+func TestStringToSign(t *testing.T) {
+	r, err := http.NewRequest(http.MethodPost, "http://LocalHost:5151/run/system/echo?b=2&a=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set(signing.ContentHashHeader, signing.HashBody([]byte("hello")))
+	expected := "POST\nlocalhost:5151\n/run/system/echo\na=1&b=2\n" +
+		"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824\n1700000000\n00ff"
+	if s := signing.StringToSign(r, 1700000000, "00ff"); s != expected {
+		t.Errorf("String to sign is %q, expected %q", s, expected)
+	}
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	if signing.Signature(secret, expected) == signing.Signature([]byte("another secret"), expected) {
+		t.Error("Signature matches with another secret")
+	}
+}
+
+func TestVerifyBody(t *testing.T) {
+	hash := signing.HashBody([]byte("hello"))
+	if !signing.ValidContentHash(hash) || signing.ValidContentHash("hello") {
+		t.Error("Content hash validation is wrong")
+	}
+
+	body, err := ioutil.ReadAll(signing.VerifyBody(ioutil.NopCloser(strings.NewReader("hello")), hash))
+	if err != nil || string(body) != "hello" {
+		t.Errorf("Verified body is (%q, %v), expected hello", string(body), err)
+	}
+	if _, err := ioutil.ReadAll(signing.VerifyBody(ioutil.NopCloser(strings.NewReader("hellO")), hash)); err != signing.ErrContentHash {
+		t.Errorf("Tampered body is read with error %v, expected %v", err, signing.ErrContentHash)
+	}
+}